@@ -0,0 +1,43 @@
+//go:build freebsd || openbsd || netbsd || dragonfly || darwin
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestStaleTokenKQueue is TestStaleToken for kqueue, whose events carry the payload in udata.
+func TestStaleTokenKQueue(t *testing.T) {
+	kq := NewKQueue()
+	defer kq.Close()
+
+	a, _ := socketpair(t)
+	dup := func() int {
+		fd, err := unix.Dup(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+
+	old := kq.Register(dup()).(*closeMapPayload)
+	old.Release(errors.New("released"))
+
+	reg := kq.Register(dup()).(*closeMapPayload)
+	if reg.fd != old.fd {
+		t.Fatalf("expected descriptor %d to be reused, got %d", old.fd, reg.fd)
+	}
+
+	if kq.fire(old) {
+		t.Fatal("stale token fired")
+	}
+	assertNotDone(t, reg.Done())
+
+	if !kq.fire(reg) {
+		t.Fatal("live token did not fire")
+	}
+	waitDone(t, reg.Done())
+}
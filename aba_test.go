@@ -0,0 +1,44 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestStaleToken feeds the worker path an event for a registration that was released after its
+// descriptor number went to a new one: the event must be dropped, not fire the new registration.
+func TestStaleToken(t *testing.T) {
+	ep := NewEpoll()
+	defer ep.Close()
+
+	a, _ := socketpair(t)
+	dup := func() int {
+		fd, err := unix.Dup(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+
+	old := ep.Register(dup()).(*closeMapPayload)
+	old.Release(errors.New("released"))
+
+	reg := ep.Register(dup()).(*closeMapPayload)
+	if reg.fd != old.fd {
+		t.Fatalf("expected descriptor %d to be reused, got %d", old.fd, reg.fd)
+	}
+
+	if ep.fire(old.token, unix.EPOLLRDHUP) {
+		t.Fatal("stale token fired")
+	}
+	assertNotDone(t, reg.Done())
+
+	if !ep.fire(reg.token, unix.EPOLLRDHUP) {
+		t.Fatal("live token did not fire")
+	}
+	waitDone(t, reg.Done())
+}
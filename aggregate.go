@@ -0,0 +1,96 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ConnError names which of the connections passed to WithContextAny or WithContextAll closed.
+type ConnError struct {
+	Index int  // position in the conns argument
+	Conn  Conn // conns[Index]
+	Err   error
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("conn %d: %v", e.Index, e.Err)
+}
+
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+func (fe *frontend) WithContextAny(ctx context.Context, conns ...Conn) context.Context {
+	return fe.withWatches(ctx, conns, false)
+}
+
+func (fe *frontend) WithContextAll(ctx context.Context, conns ...Conn) context.Context {
+	return fe.withWatches(ctx, conns, true)
+}
+
+// withWatches takes a reference on each conn's watch, as WithContext does, but waits on all of
+// them from a single goroutine. Conns that cannot be watched are left out, unless all is set:
+// then none are watched, since the Context could never be canceled by closes.
+func (fe *frontend) withWatches(ctx context.Context, conns []Conn, all bool) context.Context {
+	var (
+		ws      []*watch
+		indexes []int // into conns, by position in ws
+	)
+	for i, conn := range conns {
+		if w := fe.acquire(conn, false); w != nil {
+			ws = append(ws, w)
+			indexes = append(indexes, i)
+		}
+	}
+	if all && len(ws) < len(conns) {
+		fe.logger.Printf("WithContextAll: %d of %d conns cannot be watched", len(conns)-len(ws), len(conns))
+		for _, w := range ws {
+			fe.unref(w, context.Canceled)
+		}
+		return ctx
+	}
+	if len(ws) == 0 {
+		return ctx
+	}
+
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	cases := make([]reflect.SelectCase, len(ws)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	for i, w := range ws {
+		cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(w.reg.Done())}
+	}
+
+	go func() {
+		defer cancelCause(nil)
+
+		var (
+			cause  error
+			closed []error
+		)
+		for len(closed) < len(ws) {
+			chosen, _, _ := reflect.Select(cases)
+			if chosen == 0 {
+				cause = context.Cause(ctx)
+				break
+			}
+			i := indexes[chosen-1]
+			err := &ConnError{Index: i, Conn: conns[i], Err: ws[chosen-1].reg.Err()}
+			if !all {
+				cause = err
+				break
+			}
+			closed = append(closed, err)
+			cases[chosen].Chan = reflect.Value{} // ignored from now on
+			cause = errors.Join(closed...)
+		}
+
+		cancelCause(cause)
+		for _, w := range ws {
+			fe.unref(w, cause)
+		}
+	}()
+
+	return ctx
+}
@@ -0,0 +1,124 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWithContextAny(t *testing.T) {
+	var (
+		clients [3]*net.TCPConn
+		servers [3]Conn
+	)
+	for i := range clients {
+		clients[i], servers[i] = tcpPair(t)
+	}
+
+	ctx := WithContextAny(context.Background(), servers[:]...)
+	assertNotDone(t, ctx.Done())
+
+	clients[1].Close()
+	waitDone(t, ctx.Done())
+
+	cause := context.Cause(ctx)
+	var connErr *ConnError
+	if !errors.As(cause, &connErr) || connErr.Index != 1 || connErr.Conn != servers[1] {
+		t.Fatalf("expected a *ConnError for conn 1, got %v", cause)
+	}
+	if !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+}
+
+func TestWithContextAll(t *testing.T) {
+	client0, server0 := tcpPair(t)
+	client1, server1 := tcpPair(t)
+
+	ctx := WithContextAll(context.Background(), server0, server1)
+
+	client0.Close()
+	assertNotDone(t, ctx.Done())
+
+	client1.Close()
+	waitDone(t, ctx.Done())
+
+	cause := context.Cause(ctx)
+	if !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+	var connErr *ConnError
+	if !errors.As(cause, &connErr) {
+		t.Fatalf("expected a *ConnError, got %v", cause)
+	}
+}
+
+// TestWithContextAllUnwatchable checks that WithContextAll is not canceled by the other conns
+// closing when one of them cannot be watched, and holds no watches.
+func TestWithContextAllUnwatchable(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe := WithBackend(be)
+
+	client, server := tcpPair(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	w.Close()
+
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := fe.WithContextAll(parent, server, r)
+	if ctx != parent {
+		t.Fatal("expected the parent Context back")
+	}
+	if n, _ := be.(LimitedBackend).Watches(); n != 0 {
+		t.Fatalf("expected no watches, got %d", n)
+	}
+
+	client.Close()
+	assertNotDone(t, ctx.Done())
+}
+
+// TestWithContextAnyRelease checks that ending the parent releases every registration.
+func TestWithContextAnyRelease(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	lb, ok := be.(LimitedBackend)
+	if !ok {
+		t.Skip("backend does not report its watches")
+	}
+	fe := WithBackend(be)
+
+	_, server0 := tcpPair(t)
+	_, server1 := tcpPair(t)
+
+	parent, cancel := context.WithCancel(context.Background())
+	ctx := fe.WithContextAny(parent, server0, server1)
+	if n, _ := lb.Watches(); n != 2 {
+		t.Fatalf("expected 2 watches, got %d", n)
+	}
+
+	cancel()
+	waitDone(t, ctx.Done())
+	if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", cause)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		n, _ := lb.Watches()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected watches to be released, %d remain", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,17 +1,81 @@
 package blockuntilclosed
 
 import (
+	"context"
 	"log"
 	"sync"
+	"time"
 )
 
 // Backend is the interface for the platform-specific implementation of the package.
 type Backend interface {
+	// Done returns a channel that is closed when fd is. Once the backend has been closed it returns
+	// an already-closed channel rather than nil, so callers selecting on it cannot block forever.
+	// Done takes ownership of fd, and closes it once the registration ends; pass a dup.
 	Done(fd int) <-chan struct{}
 	SetLogger(logger *log.Logger)
 	Close() error
 }
 
+// Registration is a single watch held by a backend.
+type Registration interface {
+	// Done is closed when the watched object goes away, or the backend is closed.
+	Done() <-chan struct{}
+	// Err reports why Done was closed. It returns nil while Done is still open.
+	Err() error
+}
+
+// Releaser is implemented by registrations that can be given up before they fire.
+type Releaser interface {
+	// Release deregisters, then closes Done with cause. It reports false if the registration had
+	// already fired.
+	Release(cause error) bool
+}
+
+// RegistrationBackend is implemented by backends that report why a file descriptor was reported closed.
+type RegistrationBackend interface {
+	Backend
+	Register(fd int) Registration
+}
+
+// BorrowBackend is implemented by backends that can watch a descriptor they do not own.
+type BorrowBackend interface {
+	Backend
+	// Borrow is like Register, but fd remains the caller's: the backend deregisters it once the
+	// registration ends rather than closing it. The caller must keep fd open until then.
+	Borrow(fd int) Registration
+}
+
+// ShutdownBackend is implemented by backends that can shut down gracefully, the way
+// [net/http.Server.Shutdown] does. Close, by contrast, releases pending registrations right away.
+type ShutdownBackend interface {
+	Backend
+	// Shutdown stops accepting registrations (new ones fire at once with ErrBackendClosed), waits
+	// for pending ones to be delivered, then closes the backend. If ctx expires first, Shutdown
+	// returns its error and the backend is closed in the background; whatever is still pending
+	// is released with ErrBackendClosed.
+	Shutdown(ctx context.Context) error
+}
+
+// LimitedBackend is implemented by backends that cap concurrent registrations. Every registration
+// holds a dup'd file descriptor, so an uncapped backend can double a server's descriptor usage and
+// push accept(2) into EMFILE. Registrations over the cap fire at once with ErrTooManyWatches.
+type LimitedBackend interface {
+	Backend
+	// SetMaxWatches sets the cap. Zero or less removes it.
+	SetMaxWatches(n int)
+	// Watches reports the number of pending registrations and the cap, for use as a gauge.
+	Watches() (n, max int)
+}
+
+// ProcessBackend is implemented by backends that can also watch for process exit. A pid is only
+// meaningful until the process is reaped, after which it may be reused: callers must not pass the
+// pid of a process they may already have waited for.
+type ProcessBackend interface {
+	Backend
+	DoneProcess(pid int) Registration
+}
+
 var (
 	defaultBackendFunc func() Backend = func() Backend {
 		log.Fatal("platform not supported")
@@ -22,6 +86,36 @@ var (
 	})
 )
 
+// shutdownPollInterval is how often shutdown checks for pending registrations.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// shutdown implements Shutdown for the backends in this package.
+func shutdown(ctx context.Context, stopAccepting func(), pending func() int, closeOnce func() error) error {
+	stopAccepting()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for pending() > 0 {
+		select {
+		case <-ctx.Done():
+			go closeOnce()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- closeOnce()
+	}()
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err() // the worker is stuck; leave it be
+	}
+}
+
 // DefaultBackend retrieves a singleton instance of the default backend for the current platform.
 func DefaultBackend() Backend {
 	return defaultBackendOnceFunc()
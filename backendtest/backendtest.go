@@ -0,0 +1,304 @@
+// Package backendtest provides a conformance suite for implementations of blockuntilclosed.Backend.
+package backendtest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"jonwillia.ms/blockuntilclosed"
+)
+
+var (
+	// Timeout is how long a registration is given to fire before a scenario fails.
+	Timeout = 5 * time.Second
+	// Quiet is how long a registration must stay open in scenarios where it should not fire.
+	Quiet = 100 * time.Millisecond
+)
+
+// concurrency is the number of registrations made at once by the concurrent scenario.
+const concurrency = 64
+
+// Run runs the conformance suite, calling newBackend for a fresh backend in each scenario. The
+// backend is closed when the scenario ends.
+func Run(t *testing.T, newBackend func() blockuntilclosed.Backend) {
+	scenarios := []struct {
+		name string
+		fn   func(t *testing.T, be blockuntilclosed.Backend)
+	}{
+		{"FIN", testFIN},
+		{"RST", testRST},
+		{"HalfClose", testHalfClose},
+		{"DataThenClose", testDataThenClose},
+		{"LocalClose", testLocalClose},
+		{"Abort", testAbort},
+		{"Concurrent", testConcurrent},
+		{"BackendClose", testBackendClose},
+		{"DoneAfterClose", testDoneAfterClose},
+	}
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			be := newBackend()
+			t.Cleanup(func() {
+				be.Close()
+			})
+			s.fn(t, be)
+		})
+	}
+}
+
+// testFIN closes the peer gracefully.
+func testFIN(t *testing.T, be blockuntilclosed.Backend) {
+	conn, peer := tcpPair(t)
+	reg := register(t, be, conn)
+
+	assertOpen(t, reg.Done())
+	peer.Close()
+	waitClosed(t, reg.Done())
+	assertCause(t, reg, blockuntilclosed.ErrConnClosed)
+}
+
+// testRST aborts the peer with SO_LINGER 0, so the connection is reset rather than shut down.
+func testRST(t *testing.T, be blockuntilclosed.Backend) {
+	conn, peer := tcpPair(t)
+	reg := register(t, be, conn)
+
+	if err := peer.SetLinger(0); err != nil {
+		t.Fatal(err)
+	}
+	peer.Close()
+	waitClosed(t, reg.Done())
+	assertCause(t, reg, blockuntilclosed.ErrConnClosed)
+}
+
+// testHalfClose shuts down the peer's write side only: it will send nothing more.
+func testHalfClose(t *testing.T, be blockuntilclosed.Backend) {
+	conn, peer := tcpPair(t)
+	reg := register(t, be, conn)
+
+	if err := peer.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, reg.Done())
+}
+
+// testDataThenClose checks that incoming data alone does not fire, but the close that follows does.
+func testDataThenClose(t *testing.T, be blockuntilclosed.Backend) {
+	conn, peer := tcpPair(t)
+	reg := register(t, be, conn)
+
+	if _, err := peer.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	assertOpen(t, reg.Done())
+
+	peer.Close()
+	waitClosed(t, reg.Done())
+}
+
+// testLocalClose closes the caller's descriptor before the peer goes away. The backend owns a
+// dup of it, so the registration must still fire when the peer closes.
+func testLocalClose(t *testing.T, be blockuntilclosed.Backend) {
+	conn, peer := tcpPair(t)
+	reg := register(t, be, conn)
+
+	conn.Close()
+	assertOpen(t, reg.Done())
+
+	peer.Close()
+	waitClosed(t, reg.Done())
+}
+
+// testAbort checks that nothing fires while both ends stay open.
+func testAbort(t *testing.T, be blockuntilclosed.Backend) {
+	conn, _ := tcpPair(t)
+	reg := register(t, be, conn)
+
+	assertOpen(t, reg.Done())
+}
+
+// testConcurrent registers many connections from separate goroutines and closes every peer.
+func testConcurrent(t *testing.T, be blockuntilclosed.Backend) {
+	conns := make([]*net.TCPConn, concurrency)
+	peers := make([]*net.TCPConn, concurrency)
+	for i := range conns {
+		conns[i], peers[i] = tcpPair(t)
+	}
+
+	dones := make([]<-chan struct{}, concurrency)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fd, err := dupFD(conns[i])
+			if err != nil {
+				errs <- err
+				return
+			}
+			dones[i] = be.Done(fd)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for i := range peers {
+		peers[i].Close()
+	}
+	for i := range dones {
+		if dones[i] == nil {
+			t.Fatalf("Done(%d) returned nil", i)
+		}
+		waitClosed(t, dones[i])
+	}
+}
+
+// testBackendClose checks that closing the backend releases pending registrations.
+func testBackendClose(t *testing.T, be blockuntilclosed.Backend) {
+	conn, _ := tcpPair(t)
+	reg := register(t, be, conn)
+
+	if err := be.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, reg.Done())
+	assertCause(t, reg, blockuntilclosed.ErrBackendClosed)
+}
+
+// testDoneAfterClose checks that registrations made after Close fire at once instead of blocking.
+func testDoneAfterClose(t *testing.T, be blockuntilclosed.Backend) {
+	if err := be.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := tcpPair(t)
+	fd, err := dupFD(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := be.Done(fd)
+	if done == nil {
+		t.Fatal("Done() returned nil after Close")
+	}
+	waitClosed(t, done)
+
+	if rb, ok := be.(blockuntilclosed.RegistrationBackend); ok {
+		fd, err := dupFD(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := rb.Register(fd)
+		if reg == nil {
+			t.Fatal("Register() returned nil after Close")
+		}
+		waitClosed(t, reg.Done())
+		if err := reg.Err(); !errors.Is(err, blockuntilclosed.ErrBackendClosed) {
+			t.Fatalf("expected ErrBackendClosed, got %v", err)
+		}
+	}
+}
+
+// register hands the backend a dup of conn's descriptor, as the frontend does. Backends that
+// implement RegistrationBackend are registered through Register, so that causes can be checked.
+func register(t *testing.T, be blockuntilclosed.Backend, conn *net.TCPConn) blockuntilclosed.Registration {
+	t.Helper()
+	fd, err := dupFD(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb, ok := be.(blockuntilclosed.RegistrationBackend); ok {
+		reg := rb.Register(fd)
+		if reg == nil {
+			t.Fatal("Register() returned nil")
+		}
+		return reg
+	}
+	done := be.Done(fd)
+	if done == nil {
+		t.Fatal("Done() returned nil")
+	}
+	return doneRegistration(done)
+}
+
+// doneRegistration adapts a plain Done channel. It has no cause to report.
+type doneRegistration <-chan struct{}
+
+func (d doneRegistration) Done() <-chan struct{} { return d }
+func (d doneRegistration) Err() error            { return nil }
+
+// assertCause checks the cause reported by reg, unless the backend does not report causes.
+func assertCause(t *testing.T, reg blockuntilclosed.Registration, want error) {
+	t.Helper()
+	if _, ok := reg.(doneRegistration); ok {
+		return
+	}
+	if err := reg.Err(); !errors.Is(err, want) {
+		t.Fatalf("expected %v, got %v", want, err)
+	}
+}
+
+// waitClosed fails the test if done is not closed within Timeout.
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(Timeout):
+		t.Fatal("timed out waiting for done")
+	}
+}
+
+// assertOpen fails the test if done is closed within Quiet.
+func assertOpen(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("expected done to block")
+	case <-time.After(Quiet):
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection. Both are closed when the test ends.
+func tcpPair(t *testing.T) (conn, peer *net.TCPConn) {
+	t.Helper()
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	peer, err = net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	conn, err = l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, peer
+}
+
+// dupFD returns a dup of conn's descriptor, which the backend takes ownership of.
+func dupFD(conn syscall.Conn) (newFD int, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		newFD, err = unix.Dup(int(fd))
+	}); cerr != nil {
+		return -1, cerr
+	}
+	return newFD, err
+}
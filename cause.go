@@ -0,0 +1,56 @@
+package blockuntilclosed
+
+import "time"
+
+// CloseError is the cause recorded when a watched connection is reported closed.
+// It matches [ErrConnClosed] with [errors.Is].
+type CloseError struct {
+	// Err is the pending socket error (SO_ERROR) of a datagram socket, if the kernel reported one,
+	// such as ECONNREFUSED after an ICMP port unreachable on a connected UDP socket. Reading it
+	// clears it, so a later read on the connection will not see it. Stream sockets keep theirs for
+	// the caller's next read: a reset still surfaces there as ECONNRESET. The exception, on Linux,
+	// is a connection the kernel dropped with segments or keepalive probes unanswered: its error
+	// is read, and is ETIMEDOUT if the kernel gave up on an unresponsive peer (TCP_USER_TIMEOUT or
+	// keepalive), so the caller's next read sees an EOF instead.
+	Err error
+	// TCPInfo is a snapshot of TCP_INFO taken as soon as the disconnect was noticed, while the
+	// backend's dup'd descriptor was still open. It is nil for other sockets and on platforms
+	// without TCP_INFO.
+	TCPInfo *TCPInfo
+}
+
+// TCPInfo is the subset of the kernel's TCP_INFO that helps explain a disconnect: a lossy network
+// shows up as retransmits and unacked segments, a client that hit stop as a clean CLOSE_WAIT.
+type TCPInfo struct {
+	State         uint8 // TCP_ESTABLISHED, TCP_CLOSE_WAIT, ... as in the kernel's tcp_states.h
+	RTT           time.Duration
+	RTTVar        time.Duration
+	Retransmits   uint8  // retransmits of the current unacked segment
+	Probes        uint8  // keepalive or zero window probes sent without an answer
+	TotalRetrans  uint32 // retransmits over the connection's lifetime
+	Unacked       uint32 // segments sent and not yet acked
+	Lost          uint32
+	BytesAcked    uint64
+	BytesReceived uint64
+}
+
+// TCP states for TCPInfo.State, from the kernel's tcp_states.h.
+const (
+	tcpClose     = 7
+	tcpCloseWait = 8
+)
+
+func (e *CloseError) Error() string {
+	if e.Err == nil {
+		return ErrConnClosed.Error()
+	}
+	return ErrConnClosed.Error() + ": " + e.Err.Error()
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CloseError) Is(target error) bool {
+	return target == ErrConnClosed
+}
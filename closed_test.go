@@ -0,0 +1,73 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBackendClosed checks that a closed backend never hands out a nil channel, and that both
+// pending and new registrations are told apart from a real disconnect.
+func TestBackendClosed(t *testing.T) {
+	be := NewDefaultBackend()
+	fe := WithBackend(be)
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	pending := fe.WithContext(context.Background(), server)
+	assertNotDone(t, pending.Done())
+
+	if err := be.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkCause := func(t *testing.T, ctx context.Context) {
+		t.Helper()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for context")
+		}
+		cause := context.Cause(ctx)
+		if !errors.Is(cause, ErrBackendClosed) {
+			t.Fatalf("expected ErrBackendClosed, got %v", cause)
+		}
+		if errors.Is(cause, ErrConnClosed) {
+			t.Fatalf("expected %v not to be ErrConnClosed", cause)
+		}
+	}
+
+	t.Run("pending", func(t *testing.T) {
+		checkCause(t, pending)
+	})
+
+	t.Run("Done after Close", func(t *testing.T) {
+		done := fe.Done(server)
+		if done == nil {
+			t.Fatal("expected channel")
+		}
+		waitDone(t, done)
+	})
+
+	t.Run("WithContext after Close", func(t *testing.T) {
+		checkCause(t, fe.WithContext(context.Background(), server))
+	})
+}
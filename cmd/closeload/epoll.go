@@ -0,0 +1,11 @@
+//go:build linux
+
+package main
+
+import "jonwillia.ms/blockuntilclosed"
+
+func init() {
+	backends["epoll"] = func() blockuntilclosed.Backend {
+		return blockuntilclosed.NewEpoll()
+	}
+}
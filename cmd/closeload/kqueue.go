@@ -0,0 +1,11 @@
+//go:build freebsd || openbsd || netbsd || dragonfly || darwin
+
+package main
+
+import "jonwillia.ms/blockuntilclosed"
+
+func init() {
+	backends["kqueue"] = func() blockuntilclosed.Backend {
+		return blockuntilclosed.NewKQueue()
+	}
+}
@@ -0,0 +1,242 @@
+// Command closeload measures how quickly a backend notices disconnects at scale. It opens -n
+// loopback connections, watches the server side of each, then closes the client sides in bursts
+// and reports the latency from each client close to its channel closing, along with CPU time and
+// descriptor usage.
+//
+//	closeload -backend epoll -n 10000 -pattern rst -burst 500 -interval 10ms
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"jonwillia.ms/blockuntilclosed"
+)
+
+// backends maps -backend names to constructors; platform files add their own.
+var backends = map[string]func() blockuntilclosed.Backend{
+	"default": blockuntilclosed.NewDefaultBackend,
+}
+
+// patterns close the client side of a connection.
+var patterns = map[string]func(c *net.TCPConn) error{
+	"fin": func(c *net.TCPConn) error {
+		return c.Close()
+	},
+	"rst": func(c *net.TCPConn) error {
+		if err := c.SetLinger(0); err != nil {
+			return err
+		}
+		return c.Close()
+	},
+	"half": func(c *net.TCPConn) error {
+		return c.CloseWrite()
+	},
+}
+
+func main() {
+	var (
+		backendName = flag.String("backend", "default", "backend to measure: "+strings.Join(names(backends), ", "))
+		n           = flag.Int("n", 1000, "number of connections")
+		pattern     = flag.String("pattern", "fin", "how clients close: fin, rst, half, or a comma-separated list to cycle through")
+		burst       = flag.Int("burst", 0, "connections closed per burst; 0 closes them all at once")
+		interval    = flag.Duration("interval", 0, "pause between bursts")
+		timeout     = flag.Duration("timeout", 10*time.Second, "how long to wait for notifications after the last close")
+		verbose     = flag.Bool("v", false, "log backend activity")
+	)
+	flag.Parse()
+
+	newBackend, ok := backends[*backendName]
+	if !ok {
+		log.Fatalf("unknown backend %q", *backendName)
+	}
+	var closers []func(c *net.TCPConn) error
+	for _, name := range strings.Split(*pattern, ",") {
+		closer, ok := patterns[name]
+		if !ok {
+			log.Fatalf("unknown pattern %q", name)
+		}
+		closers = append(closers, closer)
+	}
+	if *burst <= 0 {
+		*burst = *n
+	}
+
+	raiseNofile()
+
+	be := newBackend()
+	if !*verbose {
+		be.SetLogger(log.New(io.Discard, "", 0))
+	}
+	defer be.Close()
+	if lb, ok := be.(blockuntilclosed.LimitedBackend); ok {
+		lb.SetMaxWatches(0) // measure the backend, not the cap
+	}
+	fe := blockuntilclosed.WithBackend(be)
+	if !*verbose {
+		fe.SetLogger(log.New(io.Discard, "", 0))
+	}
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	baseFDs := countFDs()
+	start := time.Now()
+	clients := make([]*net.TCPConn, 0, *n)
+	servers := make([]*net.TCPConn, 0, *n)
+	dones := make([]<-chan struct{}, 0, *n)
+	for i := 0; i < *n; i++ {
+		client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+		if err != nil {
+			log.Fatalf("dial %d: %v", i, err)
+		}
+		server, err := l.AcceptTCP()
+		if err != nil {
+			log.Fatalf("accept %d: %v", i, err)
+		}
+		done := fe.Done(server)
+		if done == nil {
+			log.Fatalf("Done(%d) returned nil", i)
+		}
+		clients = append(clients, client)
+		servers = append(servers, server)
+		dones = append(dones, done)
+	}
+	fmt.Printf("backend %s: %d connections registered in %v\n", *backendName, *n, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("descriptors: %d before, %d registered (%.1f per connection)\n",
+		baseFDs, countFDs(), float64(countFDs()-baseFDs)/float64(*n))
+
+	closedAt := make([]time.Time, *n)
+	latencies := make([]time.Duration, *n)
+	notified := make([]bool, *n)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	expired := make(chan struct{})
+	for i := range dones {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case <-dones[i]:
+			case <-expired:
+				return
+			}
+			now := time.Now()
+			mu.Lock()
+			latencies[i] = now.Sub(closedAt[i])
+			notified[i] = true
+			mu.Unlock()
+		}(i)
+	}
+
+	cpuBefore := cpuTime()
+	start = time.Now()
+	for i := 0; i < *n; i += *burst {
+		if i > 0 && *interval > 0 {
+			time.Sleep(*interval)
+		}
+		for j := i; j < i+*burst && j < *n; j++ {
+			mu.Lock()
+			closedAt[j] = time.Now()
+			mu.Unlock()
+			if err := closers[j%len(closers)](clients[j]); err != nil {
+				log.Printf("close %d: %v", j, err)
+			}
+		}
+	}
+	timer := time.AfterFunc(*timeout, func() { close(expired) })
+	wg.Wait()
+	timer.Stop()
+	elapsed := time.Since(start)
+	cpu := cpuTime() - cpuBefore
+
+	var got []time.Duration
+	for i := range latencies {
+		if notified[i] {
+			got = append(got, latencies[i])
+		}
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+
+	fmt.Printf("notified: %d of %d in %v\n", len(got), *n, elapsed.Round(time.Millisecond))
+	if len(got) > 0 {
+		fmt.Printf("latency: p50 %v p99 %v p999 %v max %v\n",
+			percentile(got, 0.50), percentile(got, 0.99), percentile(got, 0.999), got[len(got)-1])
+	}
+	fmt.Printf("cpu: %v (%.1f%% of one core)\n", cpu.Round(time.Microsecond), 100*cpu.Seconds()/elapsed.Seconds())
+	fmt.Printf("descriptors after: %d\n", countFDs())
+
+	for i := range clients {
+		clients[i].Close()
+		servers[i].Close()
+	}
+	if len(got) < *n {
+		os.Exit(1)
+	}
+}
+
+// percentile returns the p'th percentile of sorted, by nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// raiseNofile lifts the soft descriptor limit to the hard one: each connection takes three
+// descriptors (client, server and the backend's dup).
+func raiseNofile() {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		log.Printf("unix.Getrlimit(): %v", err)
+		return
+	}
+	rlim.Cur = rlim.Max
+	if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		log.Printf("unix.Setrlimit(): %v", err)
+	}
+}
+
+// countFDs returns the number of open descriptors, or -1 if it cannot tell.
+func countFDs() int {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries) - 1 // ReadDir's own descriptor
+}
+
+// cpuTime returns the user and system time used by the process so far.
+func cpuTime() time.Duration {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		log.Printf("unix.Getrusage(): %v", err)
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func names(m map[string]func() blockuntilclosed.Backend) []string {
+	var s []string
+	for name := range m {
+		s = append(s, name)
+	}
+	sort.Strings(s)
+	return s
+}
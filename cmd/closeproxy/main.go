@@ -0,0 +1,74 @@
+// Command closeproxy is a TCP proxy that tears down the upstream connection as soon as the
+// downstream client goes away, even while the upstream is silent.
+//
+//	closeproxy -listen :8080 -upstream localhost:80
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"jonwillia.ms/blockuntilclosed"
+)
+
+func main() {
+	var (
+		listen   = flag.String("listen", ":8080", "address to listen on")
+		upstream = flag.String("upstream", "", "address to forward to")
+		timeout  = flag.Duration("dial-timeout", 10*time.Second, "timeout for dialing the upstream")
+		verbose  = flag.Bool("v", false, "log backend activity")
+	)
+	flag.Parse()
+	if *upstream == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !*verbose {
+		blockuntilclosed.DefaultBackend().SetLogger(log.New(io.Discard, "", 0))
+		blockuntilclosed.DefaultFrontend().SetLogger(log.New(io.Discard, "", 0))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.Printf("forwarding %s to %s", l.Addr(), *upstream)
+
+	d := net.Dialer{Timeout: *timeout}
+	for {
+		down, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("accept: %v", err)
+			continue
+		}
+
+		go func() {
+			up, err := d.DialContext(ctx, "tcp", *upstream)
+			if err != nil {
+				log.Printf("%s: dial %s: %v", down.RemoteAddr(), *upstream, err)
+				down.Close()
+				return
+			}
+			start := time.Now()
+			cause := blockuntilclosed.Proxy(ctx, down.(*net.TCPConn), up.(*net.TCPConn))
+			log.Printf("%s: closed after %v: %v", down.RemoteAddr(), time.Since(start).Round(time.Millisecond), cause)
+		}()
+	}
+}
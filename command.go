@@ -0,0 +1,30 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// DefaultCommandGrace is how long a command started by CommandUntilClosed has to exit after
+// SIGTERM before it is killed.
+const DefaultCommandGrace = 5 * time.Second
+
+// CommandUntilClosed is like [exec.CommandContext], and also terminates the command when conn
+// closes, watched through the default frontend. Cancel sends SIGTERM, and WaitDelay,
+// [DefaultCommandGrace] by default, bounds how long the command has to exit before it is sent
+// SIGKILL; either may be changed before Start. The watch lasts until conn closes or ctx ends, so
+// cancel ctx once the command is done.
+//
+// Wait reports the command's own error, an [*exec.ExitError] for a terminated command, since
+// exec.Cmd prefers it to its Context's. For why it was terminated, pass a ctx from [WithContext] on
+// conn: the two share one watch, and once ctx is done, context.Cause(ctx) is the [*CloseError].
+func CommandUntilClosed(ctx context.Context, conn Conn, name string, arg ...string) *exec.Cmd {
+	cmd := exec.CommandContext(WithContext(ctx, conn), name, arg...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = DefaultCommandGrace
+	return cmd
+}
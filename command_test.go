@@ -0,0 +1,73 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandUntilClosed(t *testing.T) {
+	client, server := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithContext(ctx, server)
+	cmd := CommandUntilClosed(ctx, server, "sh", "-c", "trap 'exit 3' TERM; sleep 10 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(waitTime) // let sh install its trap
+	client.Close()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3 from the SIGTERM trap, got %v", err)
+	}
+	waitDone(t, ctx.Done())
+	var closeErr *CloseError
+	if cause := context.Cause(ctx); !errors.As(cause, &closeErr) {
+		t.Fatalf("expected *CloseError, got %v", cause)
+	}
+}
+
+func TestCommandUntilClosedGrace(t *testing.T) {
+	client, server := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := CommandUntilClosed(ctx, server, "sh", "-c", "trap '' TERM; exec sleep 10")
+	cmd.WaitDelay = waitTime
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(waitTime)
+
+	start := time.Now()
+	client.Close()
+	err := cmd.Wait()
+	if dur := time.Since(start); dur > 5*time.Second {
+		t.Fatalf("command ignoring SIGTERM outlived its grace period: %v", dur)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *exec.ExitError, got %v", err)
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGKILL {
+		t.Fatalf("expected SIGKILL, got %v", exitErr)
+	}
+}
+
+func TestCommandUntilClosedExits(t *testing.T) {
+	_, server := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := CommandUntilClosed(ctx, server, "sh", "-c", "exit 0")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("expected a clean exit, got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package blockuntilclosed
+
+import "golang.org/x/sys/unix"
+
+// haveSocketCookie reports whether socketCookie can identify sockets on this platform.
+const haveSocketCookie = true
+
+// socketCookie returns the kernel's unique, never-reused identifier for the socket behind fd
+// (SO_COOKIE). Every descriptor for the same socket, dup'd or not, has the same cookie.
+func socketCookie(fd int) (uint64, bool) {
+	cookie, err := unix.GetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_COOKIE)
+	if err != nil {
+		return 0, false
+	}
+	return cookie, true
+}
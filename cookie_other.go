@@ -0,0 +1,12 @@
+//go:build !linux
+
+package blockuntilclosed
+
+// haveSocketCookie reports whether socketCookie can identify sockets on this platform.
+const haveSocketCookie = false
+
+// socketCookie would need SO_COOKIE. Without it a descriptor number cannot tell a socket apart
+// from a later one that reused the number, so registrations are not shared.
+func socketCookie(fd int) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,69 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSharedWatch checks that WithContext calls on one connection, as for every request on a
+// keep-alive connection, share a registration that is released with the last of them.
+func TestSharedWatch(t *testing.T) {
+	if !haveSocketCookie {
+		t.Skip("registrations are not shared on this platform")
+	}
+
+	be := NewDefaultBackend()
+	defer be.Close()
+	lb, ok := be.(LimitedBackend)
+	if !ok {
+		t.Skip("backend does not report watches")
+	}
+	fe := WithBackend(be)
+
+	watches := func() int {
+		n, _ := lb.Watches()
+		return n
+	}
+	eventually := func(t *testing.T, expected int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for watches() != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d watches, got %d", expected, watches())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	client, server := tcpPair(t)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	req1 := fe.WithContext(ctx1, server)
+	req2 := fe.WithContext(ctx2, server)
+	eventually(t, 1)
+
+	cancel1()
+	<-req1.Done()
+	time.Sleep(waitTime)
+	eventually(t, 1) // req2 still holds it
+
+	cancel2()
+	<-req2.Done()
+	eventually(t, 0)
+
+	req3 := fe.WithContext(context.Background(), server)
+	req4 := fe.WithContext(context.Background(), server)
+	eventually(t, 1)
+
+	client.Close()
+	for _, ctx := range []context.Context{req3, req4} {
+		waitDone(t, ctx.Done())
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrConnClosed) {
+			t.Fatalf("expected ErrConnClosed, got %v", cause)
+		}
+	}
+	eventually(t, 0)
+}
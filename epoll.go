@@ -3,6 +3,7 @@
 package blockuntilclosed
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
@@ -23,10 +24,16 @@ func init() {
 type Epoll struct {
 	logger              *log.Logger
 	m                   closeMap
+	files               inotifyWatches
 	epollFD             int
+	inotifyFD           int
 	allDone             chan struct{}
 	pipeRead, pipeWrite *os.File
 	closeOnce           func() error
+
+	mu     sync.RWMutex // held for reading while registering, so close can wait them out
+	closed bool
+	limit  *watchLimit
 }
 
 func NewEpoll() *Epoll {
@@ -54,8 +61,11 @@ RETRY:
 		pipeRead:  pipeRead,
 		pipeWrite: pipeWrite,
 		allDone:   make(chan struct{}),
+		limit:     newWatchLimit(),
 	}
 	ep.closeOnce = sync.OnceValue(ep.close)
+	ep.m.beforeClose = ep.deregister
+	ep.files.publish = ep.m.publish
 
 	pipeFD := int(pipeRead.Fd())
 	err = ep.registerPipe(pipeFD)
@@ -64,6 +74,11 @@ RETRY:
 		return nil
 	}
 
+	if err := ep.startInotify(); err != nil {
+		logger.Fatalf("startInotify(): %v", err)
+		return nil
+	}
+
 	go ep.worker(pipeFD)
 
 	return ep
@@ -73,6 +88,25 @@ func (ep *Epoll) SetLogger(logger *log.Logger) {
 	ep.logger = logger
 }
 
+func (ep *Epoll) SetMaxWatches(n int) {
+	ep.limit.set(n)
+}
+
+// Watches counts pending registrations. Each holds a dup'd descriptor in the epoll set until it
+// fires or is released, when it is deregistered and stops counting. Regular files watched through
+// inotify hold none, and are not counted.
+func (ep *Epoll) Watches() (n, max int) {
+	return ep.m.Len(), ep.limit.get()
+}
+
+func (ep *Epoll) SetHooks(h Hooks) {
+	ep.m.hooks.set(h)
+}
+
+func (ep *Epoll) Subscribe(buffer int) (<-chan Event, func()) {
+	return ep.m.events.subscribe(buffer)
+}
+
 func (ep *Epoll) getMap() *closeMap {
 	return &ep.m
 }
@@ -82,15 +116,46 @@ func (ep *Epoll) Close() error {
 	return ep.closeOnce()
 }
 
+func (ep *Epoll) Shutdown(ctx context.Context) error {
+	ep.logger.Print("Shutdown()")
+	return shutdown(ctx, ep.stopAccepting, ep.pending, ep.closeOnce)
+}
+
+func (ep *Epoll) stopAccepting() {
+	ep.mu.Lock()
+	ep.closed = true
+	ep.mu.Unlock()
+}
+
+func (ep *Epoll) pending() int {
+	return ep.m.Len() + ep.files.len()
+}
+
 func (ep *Epoll) close() error {
+	ep.stopAccepting()
+
 	ep.pipeWrite.Write([]byte{0})
 	ep.logger.Print("awaiting allDone")
 	<-ep.allDone
-	count := ep.m.Drain()
+	count := ep.m.Drain(ErrBackendClosed) + ep.files.drain(ErrBackendClosed)
 	ep.logger.Printf("Drain(): %d", count)
+	ep.m.events.close()
 	return nil
 }
 
+// deregister removes fd from the interest list before it is closed. Closing the dup'd fd alone
+// would not: the entry lives as long as the open file description, which the caller still holds.
+func (ep *Epoll) deregister(fd int) {
+	select {
+	case <-ep.allDone:
+		return // epollFD is closed, and its number may have been reused
+	default:
+	}
+	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_DEL, fd, nil); err != nil && !errors.Is(err, unix.ENOENT) {
+		ep.logger.Printf("deregister unix.EpollCtl(%d): %v", fd, err)
+	}
+}
+
 func (ep *Epoll) registerPipe(cancelFD int) error {
 RETRY:
 	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_ADD, cancelFD, &unix.EpollEvent{
@@ -113,6 +178,7 @@ func (ep *Epoll) worker(cancelFD int) {
 	defer ep.pipeRead.Close()
 	defer ep.pipeWrite.Close()
 	defer unix.Close(ep.epollFD)
+	defer unix.Close(ep.inotifyFD)
 	defer close(ep.allDone)
 	var events [1]unix.EpollEvent
 
@@ -127,6 +193,7 @@ func (ep *Epoll) worker(cancelFD int) {
 		}
 		if err != nil {
 			ep.logger.Printf("unix.EpollWait(): %v", err)
+			ep.m.hooks.fail(-1, err)
 			return
 		}
 		if n == 0 {
@@ -135,26 +202,149 @@ func (ep *Epoll) worker(cancelFD int) {
 		}
 		ev := &events[0]
 		ep.logger.Printf("unix.EpollWait(): got event %+v", ev)
-		fd := int(ev.Fd)
+		token := eventToken(ev)
 
-		if fd == cancelFD {
-			ep.logger.Print("cancelFD triggered")
-			return
+		if token < firstToken {
+			switch int(token) {
+			case cancelFD:
+				ep.logger.Print("cancelFD triggered")
+				return
+			case ep.inotifyFD:
+				ep.readInotify()
+			}
+			continue
 		}
 
-		closed := ep.m.Close(fd)
-		ep.logger.Printf("Close(%d): %v", fd, closed)
+		ep.fire(token, ev.Events)
+	}
+}
+
+// fire ends the registration for token with the cause read from its descriptor. The payload is
+// taken out of the map first: a concurrent Release could otherwise close the descriptor, and its
+// number be reused, while the cause is being read from it.
+func (ep *Epoll) fire(token uint64, events uint32) bool {
+	payload, ok := ep.m.take(token)
+	if !ok {
+		ep.logger.Printf("stale event for token %#x", token)
+		return false
+	}
+	fd := payload.fd
+
+	var cause error
+	if payload.pid != 0 {
+		cause = processExitCause(fd, payload.pid)
+	} else {
+		closeErr := &CloseError{}
+		switch payload.sotype {
+		case unix.SOCK_STREAM:
+			closeErr.TCPInfo = tcpInfo(fd)
+			if events&unix.EPOLLERR != 0 && gaveUp(closeErr.TCPInfo) {
+				closeErr.Err = socketError(fd)
+			}
+		case unix.SOCK_DGRAM:
+			// Reading SO_ERROR clears it for the caller too, since the dup shares the socket. A
+			// connected datagram socket's error is not sticky anyway (the next send succeeds),
+			// but a stream socket's is the caller's only way to tell a reset from an EOF.
+			if events&unix.EPOLLERR != 0 {
+				closeErr.Err = socketError(fd)
+			}
+		}
+		cause = closeErr
+	}
+
+	ep.m.hooks.notify(fd, cause)
+	ep.m.finish(payload, cause)
+	ep.logger.Printf("Close(%d)", fd)
+	return true
+}
+
+// tokenEvent builds an epoll_event whose 64 bit data field carries token.
+func tokenEvent(events uint32, token uint64) *unix.EpollEvent {
+	return &unix.EpollEvent{
+		Events: events,
+		Fd:     int32(uint32(token)),
+		Pad:    int32(uint32(token >> 32)),
 	}
 }
 
+// eventToken recovers the token stored by tokenEvent. Descriptors registered directly by number,
+// such as the cancel pipe, come back as tokens below firstToken.
+func eventToken(ev *unix.EpollEvent) uint64 {
+	return uint64(uint32(ev.Fd)) | uint64(uint32(ev.Pad))<<32
+}
+
 func (ep *Epoll) Done(fd int) <-chan struct{} {
-	select {
-	case <-ep.allDone:
-		return nil
-	default:
+	if payload := ep.register(fd, false); payload != nil {
+		return payload.c
+	}
+	return nil
+}
+
+// Register is like Done, and also reports why the file descriptor was reported closed.
+func (ep *Epoll) Register(fd int) Registration {
+	if payload := ep.register(fd, false); payload != nil {
+		return payload
+	}
+	return nil
+}
+
+// Borrow is like Register, but fd remains the caller's: it is deregistered, not closed, once the
+// registration ends, and must stay open until then.
+func (ep *Epoll) Borrow(fd int) Registration {
+	if payload := ep.register(fd, true); payload != nil {
+		return payload
+	}
+	return nil
+}
+
+// register watches fd, closing it once the registration ends unless it is borrowed.
+func (ep *Epoll) register(fd int, borrowed bool) *closeMapPayload {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.closed {
+		ep.closeOwned(fd, borrowed)
+		return closedPayload(ErrBackendClosed)
 	}
 
-	loaded, payload := ep.m.Add(fd)
+	// EPOLLRDHUP is the peer's FIN, EPOLLERR/EPOLLHUP a reset. EPOLLIN is left out: it would fire on
+	// any data left unread (a TLS ClientHello, or bytes a hijacking handler has yet to read), not
+	// just on the EOF that follows a FIN.
+	events := uint32(unix.EPOLLRDHUP | unix.EPOLLONESHOT | unix.EPOLLERR)
+	sotype := 0
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err == nil {
+		switch stat.Mode & unix.S_IFMT {
+		case unix.S_IFREG:
+			// epoll(7) does not support regular files.
+			return ep.doneFile(fd, borrowed)
+		case unix.S_IFSOCK:
+			sotype, _ = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+			if sotype == unix.SOCK_DGRAM {
+				// Datagram sockets have no hangup; only watch for the errors (ICMP port unreachable,
+				// say) that EPOLLERR always reports. EPOLLIN would fire on every datagram.
+				events = unix.EPOLLONESHOT
+			}
+		case unix.S_IFIFO, unix.S_IFCHR:
+			// EPOLLHUP (pipe read end with all writers gone, or a tty whose master side closed) and
+			// EPOLLERR (pipe write end with all readers gone) are always reported, so ask for
+			// nothing else; EPOLLIN would fire on every write.
+			// The dup counts as one more reader or writer until the watch ends.
+			events = unix.EPOLLONESHOT
+		}
+	}
+
+	if ep.limit.full(ep.m.Len()) {
+		ep.logger.Printf("Done(): too many watches (%d)", ep.limit.get())
+		ep.closeOwned(fd, borrowed)
+		return closedPayload(ErrTooManyWatches)
+	}
+
+	loaded, payload := ep.m.add(fd, &closeMapPayload{
+		c:        make(chan struct{}),
+		borrowed: borrowed,
+		sotype:   sotype,
+	})
 	if payload == nil {
 		ep.logger.Print("nil payload; this is a problem")
 		return nil
@@ -167,22 +357,41 @@ func (ep *Epoll) Done(fd int) <-chan struct{} {
 
 	if loaded {
 		// Already added
-		return payload.c
+		return payload
 	}
 
 RETRY:
-	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_ADD, int(fd), &unix.EpollEvent{
-		Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT | unix.EPOLLERR,
-		Fd:     int32(fd),
-	}); errors.Is(err, unix.EINTR) {
+	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_ADD, fd, tokenEvent(events, payload.token)); errors.Is(err, unix.EINTR) {
 		ep.logger.Print("Done unix.EpollCtl EINTR")
 		goto RETRY
 	} else if err != nil {
 		ep.logger.Printf("unix.EpollCtl(): %v", err)
+		ep.m.hooks.fail(fd, err)
+		ep.m.Close(payload.token, err)
 		return nil
 	}
 
 	ep.logger.Printf("Done(): added %d", fd)
 
-	return payload.c
+	return payload
+}
+
+// closeOwned closes an fd that was handed over, when it will not be registered after all.
+func (ep *Epoll) closeOwned(fd int, borrowed bool) {
+	if borrowed {
+		return
+	}
+	if err := unix.Close(fd); err != nil {
+		ep.logger.Printf("unix.Close(%d): %v", fd, err)
+	}
+}
+
+// socketError reads and clears the pending error on a socket (SO_ERROR). It returns nil if there is
+// none, or if fd is not a socket. Only use it where the caller can do without it; see fire.
+func socketError(fd int) error {
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil || errno == 0 {
+		return nil
+	}
+	return unix.Errno(errno)
 }
@@ -0,0 +1,16 @@
+//go:build linux
+
+package blockuntilclosed_test
+
+import (
+	"testing"
+
+	"jonwillia.ms/blockuntilclosed"
+	"jonwillia.ms/blockuntilclosed/backendtest"
+)
+
+func TestEpollConformance(t *testing.T) {
+	backendtest.Run(t, func() blockuntilclosed.Backend {
+		return blockuntilclosed.NewEpoll()
+	})
+}
@@ -0,0 +1,169 @@
+package blockuntilclosed
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Event describes one notification delivered by a backend.
+type Event struct {
+	FD         int    // the backend's dup'd descriptor, closed by now; -1 for files watched by path
+	Cookie     uint64 // the socket cookie, where the platform has one
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Reason     error         // the registration's cause, as from Registration.Err
+	Age        time.Duration // from registration to notification
+	Time       time.Time
+	// Dropped is the number of events this subscriber missed just before this one because its
+	// buffer was full.
+	Dropped uint64
+}
+
+// EventBackend is implemented by backends that publish every notification they deliver,
+// including releases and the drain on Close, for auditing.
+type EventBackend interface {
+	Backend
+	// Subscribe returns a channel of events and a func to stop them. The backend never waits for
+	// a subscriber: an event that does not fit in the buffer is dropped and counted in the next
+	// one's Dropped. The channel is closed by cancel, or when the backend is closed.
+	Subscribe(buffer int) (events <-chan Event, cancel func())
+}
+
+// eventHub fans events out to subscribers.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+	n      atomic.Int32 // len(subs), for a lock-free check on the registration path
+}
+
+type subscriber struct {
+	c       chan Event
+	dropped uint64
+}
+
+func (h *eventHub) subscribe(buffer int) (<-chan Event, func()) {
+	s := &subscriber{c: make(chan Event, buffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.c)
+		return s.c, func() {}
+	}
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[s] = struct{}{}
+	h.n.Add(1)
+
+	return s.c, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[s]; ok {
+			delete(h.subs, s)
+			h.n.Add(-1)
+			close(s.c)
+		}
+	}
+}
+
+// active reports whether anyone is subscribed.
+func (h *eventHub) active() bool {
+	return h.n.Load() > 0
+}
+
+func (h *eventHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		ev.Dropped = s.dropped
+		select {
+		case s.c <- ev:
+			s.dropped = 0
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// close closes every subscriber's channel, and any later subscriber's at once.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		close(s.c)
+	}
+	h.subs = nil
+	h.closed = true
+	h.n.Store(0)
+}
+
+// describe records what an Event will need to know about fd, while it is still open and connected.
+func (p *closeMapPayload) describe(fd int) {
+	if p.described {
+		return
+	}
+	p.described = true
+	p.cookie, _ = socketCookie(fd)
+
+	sotype, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return // not a socket
+	}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		p.localAddr = sockaddrToAddr(sa, sotype)
+	}
+	if sa, err := unix.Getpeername(fd); err == nil {
+		p.remoteAddr = sockaddrToAddr(sa, sotype)
+	}
+}
+
+func (p *closeMapPayload) event(now time.Time) Event {
+	return Event{
+		FD:         p.fd,
+		Cookie:     p.cookie,
+		LocalAddr:  p.localAddr,
+		RemoteAddr: p.remoteAddr,
+		Reason:     p.cause,
+		Age:        now.Sub(p.registered),
+		Time:       now,
+	}
+}
+
+func sockaddrToAddr(sa unix.Sockaddr, sotype int) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return inetAddr(sa.Addr[:], sa.Port, "", sotype)
+	case *unix.SockaddrInet6:
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return inetAddr(sa.Addr[:], sa.Port, zone, sotype)
+	case *unix.SockaddrUnix:
+		network := "unix"
+		switch sotype {
+		case unix.SOCK_DGRAM:
+			network = "unixgram"
+		case unix.SOCK_SEQPACKET:
+			network = "unixpacket"
+		}
+		return &net.UnixAddr{Name: sa.Name, Net: network}
+	}
+	return nil
+}
+
+func inetAddr(ip net.IP, port int, zone string, sotype int) net.Addr {
+	ip = append(net.IP(nil), ip...)
+	if sotype == unix.SOCK_DGRAM {
+		return &net.UDPAddr{IP: ip, Port: port, Zone: zone}
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+}
@@ -0,0 +1,110 @@
+package blockuntilclosed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newEventBackend(t *testing.T) EventBackend {
+	t.Helper()
+	eb, ok := NewDefaultBackend().(EventBackend)
+	if !ok {
+		t.Skip("backend does not publish events")
+	}
+	t.Cleanup(func() { eb.Close() })
+	return eb
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+func TestSubscribe(t *testing.T) {
+	eb := newEventBackend(t)
+	events, cancel := eb.Subscribe(16)
+	defer cancel()
+	fe := WithBackend(eb)
+
+	client, server := tcpPair(t)
+	done := fe.Done(server)
+	client.Close()
+	waitDone(t, done)
+
+	ev := nextEvent(t, events)
+	if !errors.Is(ev.Reason, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", ev.Reason)
+	}
+	if haveSocketCookie && ev.Cookie == 0 {
+		t.Fatal("expected a socket cookie")
+	}
+	if ev.LocalAddr == nil || ev.LocalAddr.String() != server.LocalAddr().String() {
+		t.Fatalf("expected local address %v, got %v", server.LocalAddr(), ev.LocalAddr)
+	}
+	if ev.RemoteAddr == nil || ev.RemoteAddr.String() != server.RemoteAddr().String() {
+		t.Fatalf("expected remote address %v, got %v", server.RemoteAddr(), ev.RemoteAddr)
+	}
+	if ev.Age <= 0 || ev.Time.IsZero() {
+		t.Fatalf("expected an age and a timestamp, got %v and %v", ev.Age, ev.Time)
+	}
+}
+
+// TestSubscribeOverflow checks that a full subscriber loses events, rather than stalling the
+// backend, and is told how many.
+func TestSubscribeOverflow(t *testing.T) {
+	eb := newEventBackend(t)
+	events, cancel := eb.Subscribe(1)
+	defer cancel()
+	fe := WithBackend(eb)
+
+	for i := 0; i < 3; i++ {
+		client, server := tcpPair(t)
+		done := fe.Done(server)
+		client.Close()
+		waitDone(t, done)
+	}
+	if ev := nextEvent(t, events); ev.Dropped != 0 {
+		t.Fatalf("expected the first event to fit, got %d dropped", ev.Dropped)
+	}
+
+	client, server := tcpPair(t)
+	done := fe.Done(server)
+	client.Close()
+	waitDone(t, done)
+	if ev := nextEvent(t, events); ev.Dropped != 2 {
+		t.Fatalf("expected 2 dropped, got %d", ev.Dropped)
+	}
+}
+
+func TestSubscribeBackendClose(t *testing.T) {
+	eb := newEventBackend(t)
+	events, cancel := eb.Subscribe(16)
+	defer cancel()
+	fe := WithBackend(eb)
+
+	_, server := tcpPair(t)
+	fe.Done(server)
+	eb.Close()
+
+	if ev := nextEvent(t, events); !errors.Is(ev.Reason, ErrBackendClosed) {
+		t.Fatalf("expected ErrBackendClosed, got %v", ev.Reason)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expected no more events")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected events to be closed")
+	}
+}
@@ -2,13 +2,16 @@ package blockuntilclosed
 
 import (
 	"os"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
 )
 
 func TestFile(t *testing.T) {
-	t.Skip("skipping test that doesn't work on darwin")
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping test that only works on linux")
+	}
 
 	f, err := os.CreateTemp("", "test")
 	if err != nil {
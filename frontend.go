@@ -2,9 +2,11 @@ package blockuntilclosed
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"sync"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -14,7 +16,14 @@ import (
 type Frontend interface {
 	Done(conn Conn) <-chan struct{}
 	WithContext(ctx context.Context, conn Conn) context.Context
+	DoneFD(fd int, opts FDOptions) <-chan struct{}
+	WithContextFD(ctx context.Context, fd int, opts FDOptions) (context.Context, context.CancelFunc)
+	WithContextAny(ctx context.Context, conns ...Conn) context.Context
+	WithContextAll(ctx context.Context, conns ...Conn) context.Context
+	DoneProcess(p *os.Process) <-chan struct{}
+	WithProcessContext(ctx context.Context, p *os.Process) context.Context
 	SetLogger(logger *log.Logger)
+	SetHooks(h Hooks)
 }
 
 var (
@@ -30,66 +39,356 @@ func DefaultFrontend() Frontend {
 
 // WithBackend returns a new instance of the frontend with the specified backend.
 func WithBackend(b Backend) Frontend {
-	return newFrontend(b)
+	return newFrontend(b, WatchOptions{})
+}
+
+// WithBackendOptions returns a new instance of the frontend with the specified backend, which
+// applies opts to every socket it watches. Frontends are cheap; several may share one backend.
+// It returns an error if opts are inconsistent or not supported on this platform.
+func WithBackendOptions(b Backend, opts WatchOptions) (Frontend, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return newFrontend(b, opts), nil
 }
 
 type frontend struct {
 	backend Backend
 	logger  *log.Logger
+	opts    WatchOptions
+	hooks   hookSet
+
+	mu     sync.Mutex
+	shared map[uint64]*watch // by socket cookie
+
+	stalls *stallPoller // nil unless opts ask for it
+}
+
+// watch is the frontend's handle on a backend registration. Repeated Done and WithContext calls
+// on one socket (every request on a keep-alive connection, say) share a single watch, and so a
+// single dup'd descriptor and kernel registration.
+//
+// Done cannot tell when its caller loses interest, so it pins the watch until it fires. Each
+// WithContext holds a reference instead, dropped when its parent Context ends; the registration is
+// released once the last reference is gone and nothing pinned it.
+type watch struct {
+	reg    Registration
+	cookie uint64
+	shared bool // listed in frontend.shared under cookie
+	refs   int
+	pinned bool
 }
 
-func newFrontend(b Backend) *frontend {
+func newFrontend(b Backend, opts WatchOptions) *frontend {
 	logger := log.New(os.Stderr, "blockuntilclosed: ", log.LstdFlags)
 
-	return &frontend{
+	fe := &frontend{
 		backend: b,
 		logger:  logger,
+		opts:    opts,
+	}
+	if opts.stalls() {
+		fe.stalls = newStallPoller(opts)
 	}
+	return fe
+}
+
+// chanRegistration adapts a plain Backend's channel to a Registration.
+type chanRegistration <-chan struct{}
+
+func (r chanRegistration) Done() <-chan struct{} {
+	return r
+}
+
+func (r chanRegistration) Err() error {
+	select {
+	case <-r:
+		return ErrConnClosed
+	default:
+		return nil
+	}
+}
+
+func (fe *frontend) register(fd int) Registration {
+	if rb, ok := fe.backend.(RegistrationBackend); ok {
+		return rb.Register(fd)
+	}
+	if done := fe.backend.Done(fd); done != nil {
+		return chanRegistration(done)
+	}
+	return nil
 }
 
 func (fe *frontend) Done(conn Conn) <-chan struct{} {
+	w := fe.acquire(conn, true)
+	if w == nil {
+		return nil
+	}
+	return w.reg.Done()
+}
+
+// acquire finds or makes the watch for conn, then pins it or takes a reference on it.
+func (fe *frontend) acquire(conn Conn, pin bool) *watch {
 	sconn, err := conn.SyscallConn()
 
 	if err != nil {
 		fe.logger.Printf("conn.SyscallConn(): %v", err)
+		fe.hooks.fail(-1, err)
 		return nil
 	}
 	var (
-		done <-chan struct{}
+		w *watch
 	)
 
 	if err := sconn.Control(func(fd uintptr) {
-		newFD, err := unix.Dup(int(fd))
-		if err != nil {
-			fe.logger.Printf("unix.Dup(): %v", err)
-			return
+		fe.mu.Lock()
+		defer fe.mu.Unlock()
+
+		cookie, shared := socketCookie(int(fd))
+		if shared {
+			w = fe.shared[cookie]
+		}
+		if w != nil && fired(w.reg) {
+			w = nil // forget has yet to catch up
+		}
+		if w == nil {
+			reg := fe.registerFD(int(fd))
+			if reg == nil {
+				return
+			}
+			w = &watch{
+				reg:    reg,
+				cookie: cookie,
+				shared: shared && !fired(reg),
+			}
+			if w.shared {
+				if fe.shared == nil {
+					fe.shared = make(map[uint64]*watch)
+				}
+				fe.shared[cookie] = w
+				go fe.forget(w)
+			}
+			if fe.stalls != nil && !fired(reg) {
+				if _, ok := reg.(Releaser); ok {
+					fe.stalls.add(w, sconn)
+				} else {
+					fe.logger.Printf("backend %T cannot release watches; not polling for stalls", fe.backend)
+				}
+			}
 		}
-		// fe.logger.Printf("newFD: %d->%d", fd, newFD)
 
-		done = fe.backend.Done(newFD)
+		if pin {
+			w.pinned = true
+		} else {
+			w.refs++
+		}
 	}); err != nil {
 		fe.logger.Printf("sconn.Control(): %v", err)
+		fe.hooks.fail(-1, err)
+	}
+
+	return w
+}
+
+// registerFD registers a dup of fd, which the backend takes ownership of.
+func (fe *frontend) registerFD(fd int) Registration {
+	// Refuse before dup'ing: at the cap, the dup is what would hit EMFILE.
+	if lb, ok := fe.backend.(LimitedBackend); ok {
+		if n, max := lb.Watches(); max > 0 && n >= max {
+			fe.logger.Printf("too many watches (%d)", max)
+			return closedPayload(ErrTooManyWatches)
+		}
+	}
+
+	if !fe.opts.isZero() {
+		if err := fe.opts.apply(fd); err != nil {
+			fe.logger.Printf("WatchOptions: %v", err)
+		}
+	}
+
+	newFD, err := unix.Dup(fd)
+	if err != nil {
+		fe.logger.Printf("unix.Dup(): %v", err)
+		fe.hooks.fail(fd, err)
+		return nil
 	}
+	// fe.logger.Printf("newFD: %d->%d", fd, newFD)
 
-	return done
+	fe.hooks.register(newFD)
+	return fe.register(newFD)
+}
+
+func fired(reg Registration) bool {
+	select {
+	case <-reg.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// forget unlists a shared watch once it has fired, so the next call on its socket registers anew.
+func (fe *frontend) forget(w *watch) {
+	<-w.reg.Done()
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.shared[w.cookie] == w {
+		delete(fe.shared, w.cookie)
+	}
+}
+
+// unref drops a reference taken by acquire, releasing the registration if it was the last.
+func (fe *frontend) unref(w *watch, cause error) {
+	fe.mu.Lock()
+	w.refs--
+	last := w.refs == 0 && !w.pinned
+	if last && w.shared && fe.shared[w.cookie] == w {
+		delete(fe.shared, w.cookie)
+	}
+	fe.mu.Unlock()
+
+	if !last {
+		return
+	}
+	if r, ok := w.reg.(Releaser); ok {
+		r.Release(cause)
+	}
 }
 
 func (fe *frontend) WithContext(ctx context.Context, conn Conn) context.Context {
+	ctx, _ = fe.withContextStop(ctx, conn)
+	return ctx
+}
+
+// withContextStop is WithContext, and also returns a func that releases the watch before it
+// returns, then cancels the Context with its cause.
+func (fe *frontend) withContextStop(ctx context.Context, conn Conn) (context.Context, func(cause error)) {
+	w := fe.acquire(conn, false)
+	if w == nil {
+		return ctx, func(error) {}
+	}
+	info := newConnInfo(conn)
+	ctx, stop := withRegistration(ctx, w.reg, func(cause error) {
+		fe.unref(w, cause)
+	}, info.closed)
+	return context.WithValue(ctx, infoKey{}, info), stop
+}
+
+// stopper is implemented by this package's frontends. Helpers that close a watched connection
+// themselves must release its watch first: the backend's dup would otherwise keep the socket
+// open, and hold back the FIN, until an asynchronous release caught up.
+type stopper interface {
+	withContextStop(ctx context.Context, conn Conn) (context.Context, func(cause error))
+}
+
+// withContextStop is fe.WithContext with a func that releases the watch. Frontends from other
+// packages only get the parent canceled, and release whenever they get to it.
+func withContextStop(fe Frontend, ctx context.Context, conn Conn) (context.Context, func(cause error)) {
+	if s, ok := fe.(stopper); ok {
+		return s.withContextStop(ctx, conn)
+	}
+	inner, cancel := context.WithCancelCause(ctx)
+	watched := fe.WithContext(inner, conn)
+	if watched == inner { // conn could not be watched
+		cancel(nil)
+		return ctx, func(error) {}
+	}
+	return watched, cancel
+}
+
+// withRegistration derives a Context that is canceled with reg's cause once it fires. reg is made
+// before the Context is returned, so a backend Shutdown that follows will wait for it. If the
+// parent Context ends first, release is called with its cause. If fire is not nil, it is called
+// with reg's cause just before the Context is canceled by it.
+//
+// The returned stop func calls release synchronously, unless reg has already fired or been
+// released, then cancels the Context. Canceling the parent releases too, but asynchronously.
+func withRegistration(ctx context.Context, reg Registration, release func(cause error), fire func(cause error)) (context.Context, func(cause error)) {
+	if reg == nil {
+		return ctx, func(error) {}
+	}
+
 	ctx, cancelCause := context.WithCancelCause(ctx)
+	var once sync.Once
+	stop := func(cause error) {
+		if cause == nil {
+			cause = context.Canceled
+		}
+		once.Do(func() { release(cause) })
+		cancelCause(cause)
+	}
 	go func() {
 		defer cancelCause(nil)
-		done := fe.Done(conn)
 		select {
-		case <-done:
-			cancelCause(ErrConnClosed)
+		case <-reg.Done():
+			once.Do(func() {}) // fired: nothing left to release
+			cause := reg.Err()
+			if fire != nil {
+				fire(cause)
+			}
+			cancelCause(cause)
 		case <-ctx.Done():
-			cancelCause(context.Cause(ctx))
+			stop(context.Cause(ctx))
 		}
 	}()
 
+	return ctx, stop
+}
+
+// releaseRegistration returns a release func for withRegistration that gives up reg outright.
+func releaseRegistration(reg Registration) func(cause error) {
+	return func(cause error) {
+		if r, ok := reg.(Releaser); ok {
+			r.Release(cause)
+		}
+	}
+}
+
+func (fe *frontend) registerProcess(p *os.Process) Registration {
+	pb, ok := fe.backend.(ProcessBackend)
+	if !ok {
+		fe.logger.Printf("backend %T does not support processes", fe.backend)
+		return nil
+	}
+
+	// A bare pid may name some other process once p has been waited for and its pid reused, but p
+	// knows whether it has been. Check before opening the watch, and again after, in case a Wait
+	// raced with it.
+	if reaped(p) {
+		return closedPayload(&ProcessExitError{Pid: p.Pid})
+	}
+	reg := pb.DoneProcess(p.Pid)
+	if reg != nil && !fired(reg) && reaped(p) {
+		cause := &ProcessExitError{Pid: p.Pid}
+		releaseRegistration(reg)(cause)
+		return closedPayload(cause)
+	}
+	return reg
+}
+
+func reaped(p *os.Process) bool {
+	return errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+func (fe *frontend) DoneProcess(p *os.Process) <-chan struct{} {
+	reg := fe.registerProcess(p)
+	if reg == nil {
+		return nil
+	}
+	return reg.Done()
+}
+
+func (fe *frontend) WithProcessContext(ctx context.Context, p *os.Process) context.Context {
+	reg := fe.registerProcess(p)
+	ctx, _ = withRegistration(ctx, reg, releaseRegistration(reg), nil)
 	return ctx
 }
 
 func (fe *frontend) SetLogger(logger *log.Logger) {
 	fe.logger = logger
 }
+
+func (fe *frontend) SetHooks(h Hooks) {
+	fe.hooks.set(h)
+}
@@ -2,4 +2,4 @@ module jonwillia.ms/blockuntilclosed
 
 go 1.18
 
-require golang.org/x/sys v0.29.0 // indirect
+require golang.org/x/sys v0.29.0
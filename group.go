@@ -0,0 +1,156 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrGroupClosed is the cause for a group's watches when Close is given no cause of its own.
+var ErrGroupClosed = errors.New("group closed")
+
+// Group tracks the watches made through it so that they can be ended together, during a server's
+// graceful shutdown for example, without closing a backend that other servers share.
+type Group struct {
+	fe     Frontend
+	logger *log.Logger
+
+	mu     sync.Mutex
+	closed bool
+	cause  error                     // Close's cause, once closed
+	stops  map[int]func(cause error) // by watch, releasing it synchronously
+	next   int
+	wg     sync.WaitGroup // one per active watch
+
+	active, total, fired, released atomic.Int64
+}
+
+// GroupStats counts a group's watches.
+type GroupStats struct {
+	Active   int // registered and not yet ended
+	Total    int // registered over the group's lifetime
+	Fired    int // ended because the connection closed
+	Released int // ended by Close or by the caller's Context
+}
+
+// NewGroup returns a group that watches through fe, or the default frontend if fe is nil.
+func NewGroup(fe Frontend) *Group {
+	if fe == nil {
+		fe = DefaultFrontend()
+	}
+	return &Group{
+		fe:     fe,
+		logger: log.New(os.Stderr, "blockuntilclosed group: ", log.LstdFlags),
+	}
+}
+
+func (g *Group) SetLogger(logger *log.Logger) {
+	g.logger = logger
+}
+
+// Done is like [Frontend.Done], but the channel is also closed by Close. Unlike Frontend.Done, the
+// watch is not pinned: Close releases it.
+func (g *Group) Done(conn Conn) <-chan struct{} {
+	ctx, ok := g.watch(context.Background(), conn)
+	if !ok {
+		return nil
+	}
+	return ctx.Done()
+}
+
+// WithContext is like [Frontend.WithContext], but the Context is also canceled by Close, with
+// Close's cause.
+func (g *Group) WithContext(ctx context.Context, conn Conn) context.Context {
+	ctx, _ = g.watch(ctx, conn)
+	return ctx
+}
+
+// watch reports false if conn could not be watched, in which case ctx is returned as is.
+func (g *Group) watch(ctx context.Context, conn Conn) (context.Context, bool) {
+	g.mu.Lock()
+	if g.closed {
+		cause := g.cause
+		g.mu.Unlock()
+		ctx, cancel := context.WithCancelCause(ctx)
+		cancel(cause)
+		return ctx, true
+	}
+	g.wg.Add(1)
+	g.mu.Unlock()
+
+	watched, stop := withContextStop(g.fe, ctx, conn)
+	if watched == ctx {
+		g.wg.Done()
+		return ctx, false
+	}
+
+	g.mu.Lock()
+	id := g.next
+	g.next++
+	closed, cause := g.closed, g.cause
+	if !closed {
+		if g.stops == nil {
+			g.stops = make(map[int]func(cause error))
+		}
+		g.stops[id] = stop
+	}
+	g.mu.Unlock()
+
+	g.active.Add(1)
+	g.total.Add(1)
+	context.AfterFunc(watched, func() {
+		g.mu.Lock()
+		delete(g.stops, id)
+		g.mu.Unlock()
+		if errors.Is(context.Cause(watched), ErrConnClosed) {
+			g.fired.Add(1)
+		} else {
+			g.released.Add(1)
+		}
+		g.active.Add(-1)
+		g.wg.Done()
+	})
+	if closed { // Close ran while conn was being registered
+		stop(cause)
+	}
+	return watched, true
+}
+
+// Close releases every watch in the group and cancels their Contexts with cause, or
+// ErrGroupClosed if cause is nil. The watches are released before it returns, so their dup'd
+// descriptors no longer hold the connections open. Watches made afterwards end at once with the
+// same cause. Close does not close the frontend's backend.
+func (g *Group) Close(cause error) {
+	if cause == nil {
+		cause = ErrGroupClosed
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return
+	}
+	g.closed = true
+	g.cause = cause
+	stops := g.stops
+	g.stops = nil
+	g.mu.Unlock()
+
+	for _, stop := range stops {
+		stop(cause)
+	}
+	g.wg.Wait()
+	g.logger.Printf("Close(): released %d watches: %v", len(stops), cause)
+}
+
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Active:   int(g.active.Load()),
+		Total:    int(g.total.Load()),
+		Fired:    int(g.fired.Load()),
+		Released: int(g.released.Load()),
+	}
+}
@@ -0,0 +1,76 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroupClose(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe := WithBackend(be)
+	g := NewGroup(fe)
+
+	_, server0 := tcpPair(t)
+	_, server1 := tcpPair(t)
+	_, server2 := tcpPair(t)
+
+	ctx := g.WithContext(context.Background(), server0)
+	done := g.Done(server1)
+	outside := fe.WithContext(context.Background(), server2)
+
+	errShutdown := errors.New("shutting down")
+	g.Close(errShutdown)
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("expected Close to have canceled the group's Context")
+	}
+	if cause := context.Cause(ctx); cause != errShutdown {
+		t.Fatalf("expected the Close cause, got %v", cause)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("expected Close to have closed the group's Done")
+	}
+	assertNotDone(t, outside.Done())
+
+	if stats := g.Stats(); stats != (GroupStats{Total: 2, Released: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// The group's watches must be released by the time Close returns, not some time after.
+	if n, _ := be.(LimitedBackend).Watches(); n != 1 {
+		t.Fatalf("expected only the outside watch to remain, got %d", n)
+	}
+
+	late := g.WithContext(context.Background(), server2)
+	if cause := context.Cause(late); cause != errShutdown {
+		t.Fatalf("expected a watch after Close to end at once with its cause, got %v", cause)
+	}
+}
+
+func TestGroupFired(t *testing.T) {
+	g := NewGroup(nil)
+	defer g.Close(nil)
+
+	client, server := tcpPair(t)
+	ctx := g.WithContext(context.Background(), server)
+
+	client.Close()
+	waitDone(t, ctx.Done())
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for g.Stats() != (GroupStats{Total: 1, Fired: 1}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", g.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
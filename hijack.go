@@ -0,0 +1,112 @@
+package blockuntilclosed
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hijackPollInterval is how often the watches of hijacked connections are checked for a local close.
+const hijackPollInterval = 50 * time.Millisecond
+
+// HijackWithContext hijacks the connection behind w, for a protocol upgraded from HTTP, and returns
+// a Context that is canceled when the client disconnects. The connection is watched through the
+// default frontend. The Context keeps r.Context()'s values but not its cancellation, which comes
+// when the handler returns. The net.Conn and [bufio.ReadWriter] are the ones [http.Hijacker]
+// returns, untouched.
+//
+// Bytes the server had already read past the request are left in the returned [bufio.ReadWriter];
+// they are data, not a close, and do not cancel the Context. A client that sends data and then
+// closes may still have some buffered there after the Context is canceled.
+//
+// The backend's dup would hold the socket open after the server closed the connection, and keep the
+// client waiting for its FIN. Hijacked connections are polled for that instead: within 50ms of the
+// server closing one, its watch is released and the Context canceled with [net.ErrClosed].
+func HijackWithContext(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, context.Context, error) {
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sconn, err := mustUnwrap(conn)
+	if err == nil {
+		var rc syscall.RawConn
+		if rc, err = sconn.SyscallConn(); err == nil {
+			// The request's Context is canceled when the handler returns, which a hijacking
+			// handler typically does long before the connection is done with; keep its values only.
+			ctx, stop := withContextStop(DefaultFrontend(), context.WithoutCancel(r.Context()), sconn)
+			hijackPolls.add(&hijackWatch{rc: rc, ctx: ctx, stop: stop})
+			return conn, brw, ctx, nil
+		}
+	}
+	conn.Close()
+	return nil, nil, nil, err
+}
+
+// hijackPolls polls hijacked connections, from one goroutine that runs only while there is something
+// to poll.
+var hijackPolls hijackPoller
+
+type hijackPoller struct {
+	mu      sync.Mutex
+	watches map[*hijackWatch]struct{}
+	running bool
+}
+
+type hijackWatch struct {
+	rc   syscall.RawConn // the caller's connection, whose Control fails once it is closed
+	ctx  context.Context
+	stop func(cause error)
+}
+
+func (hp *hijackPoller) add(hw *hijackWatch) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.watches == nil {
+		hp.watches = make(map[*hijackWatch]struct{})
+	}
+	hp.watches[hw] = struct{}{}
+	if !hp.running {
+		hp.running = true
+		go hp.run()
+	}
+}
+
+func (hp *hijackPoller) run() {
+	ticker := time.NewTicker(hijackPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !hp.poll() {
+			return
+		}
+	}
+}
+
+// poll releases the watches of connections that have been closed. It reports false, and the
+// poller stops, once nothing is left to poll.
+func (hp *hijackPoller) poll() bool {
+	var closed []*hijackWatch
+
+	hp.mu.Lock()
+	for hw := range hp.watches {
+		if hw.ctx.Err() != nil {
+			delete(hp.watches, hw)
+			continue
+		}
+		if err := hw.rc.Control(func(uintptr) {}); err != nil {
+			delete(hp.watches, hw)
+			closed = append(closed, hw)
+		}
+	}
+	more := len(hp.watches) > 0
+	hp.running = more
+	hp.mu.Unlock()
+
+	for _, hw := range closed {
+		hw.stop(net.ErrClosed)
+	}
+	return more
+}
@@ -0,0 +1,112 @@
+package blockuntilclosed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type hijacked struct {
+	conn net.Conn
+	brw  *bufio.ReadWriter
+	ctx  context.Context
+	err  error
+}
+
+func TestHijackWithContext(t *testing.T) {
+	results := make(chan hijacked, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, ctx, err := HijackWithContext(w, r)
+		results <- hijacked{conn, brw, ctx, err}
+	}))
+	defer srv.Close()
+
+	client, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Bytes sent along with the request end up buffered by the server.
+	if _, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\nhello"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-results
+	if h.err != nil {
+		t.Fatal(h.err)
+	}
+	defer h.conn.Close()
+
+	// The handler has returned; the Context must outlive it, and the buffered bytes are not a close.
+	assertNotDone(t, h.ctx.Done())
+
+	if _, err := io.WriteString(client, " world"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	waitDone(t, h.ctx.Done())
+	if cause := context.Cause(h.ctx); !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+
+	// Nothing sent before the close is lost.
+	got, err := io.ReadAll(h.brw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("expected %q, got %q", "hello world", got)
+	}
+}
+
+// TestHijackServerCloses checks that closing the hijacked conn releases the watch, so that the
+// client sees its EOF rather than waiting until it hangs up itself.
+func TestHijackServerCloses(t *testing.T) {
+	be := DefaultBackend().(LimitedBackend)
+	before, _ := be.Watches()
+
+	results := make(chan hijacked, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, ctx, err := HijackWithContext(w, r)
+		results <- hijacked{conn, brw, ctx, err}
+	}))
+	defer srv.Close()
+
+	client, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-results
+	if h.err != nil {
+		t.Fatal(h.err)
+	}
+	if _, ok := h.conn.(*net.TCPConn); !ok {
+		t.Fatalf("expected the hijacked *net.TCPConn, got %T", h.conn)
+	}
+	if err := h.conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, h.ctx.Done())
+	if cause := context.Cause(h.ctx); !errors.Is(cause, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", cause)
+	}
+	if n, _ := be.Watches(); n > before {
+		t.Fatalf("expected at most %d watches once the conn was closed, got %d", before, n)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
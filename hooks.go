@@ -0,0 +1,65 @@
+package blockuntilclosed
+
+import "sync/atomic"
+
+// Hooks are called at points in a watch's life, for tracing and instrumentation. Every field is
+// optional. fd is the backend's dup'd descriptor, whose number is not reused until OnRelease has
+// been called for it, so it ties the calls for one watch together. A descriptor borrowed from the
+// caller (see [FDOptions]) is only as stable as the caller keeps it: open until the watch ends, as
+// it must.
+//
+// A Frontend calls OnRegister and OnError; a backend calls all four. Hooks are called
+// synchronously, and concurrently, from whichever goroutine reached the point: OnNotify from the
+// backend's single worker, OnRelease from the worker or from whoever released or closed. They
+// must not block, since a slow hook delays every notification behind it, and must not register
+// or release watches themselves. Regular files watched through inotify are not reported.
+type Hooks struct {
+	// OnRegister is called once fd is registered, before it can be notified or released.
+	OnRegister func(fd int)
+	// OnNotify is called when the kernel reports fd, with the cause about to be recorded.
+	OnNotify func(fd int, reason error)
+	// OnRelease is called when a registration ends, for whatever reason, just before fd is closed:
+	// after OnNotify, on Release, or when the backend is closed.
+	OnRelease func(fd int, cause error)
+	// OnError is called when a registration or the worker's poll fails. fd is -1 if there is none.
+	OnError func(fd int, err error)
+}
+
+// HookedBackend is implemented by backends that call Hooks.
+type HookedBackend interface {
+	Backend
+	SetHooks(h Hooks)
+}
+
+// hookSet holds Hooks that may be replaced while in use.
+type hookSet struct {
+	p atomic.Pointer[Hooks]
+}
+
+func (hs *hookSet) set(h Hooks) {
+	hs.p.Store(&h)
+}
+
+func (hs *hookSet) register(fd int) {
+	if h := hs.p.Load(); h != nil && h.OnRegister != nil {
+		h.OnRegister(fd)
+	}
+}
+
+func (hs *hookSet) notify(fd int, reason error) {
+	if h := hs.p.Load(); h != nil && h.OnNotify != nil {
+		h.OnNotify(fd, reason)
+	}
+}
+
+func (hs *hookSet) release(fd int, cause error) {
+	if h := hs.p.Load(); h != nil && h.OnRelease != nil {
+		h.OnRelease(fd, cause)
+	}
+}
+
+func (hs *hookSet) fail(fd int, err error) {
+	if h := hs.p.Load(); h != nil && h.OnError != nil {
+		h.OnError(fd, err)
+	}
+}
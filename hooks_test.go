@@ -0,0 +1,110 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookLog records hook calls in order.
+type hookLog struct {
+	mu    sync.Mutex
+	calls []string
+	errs  []error
+}
+
+func (hl *hookLog) record(call string) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	hl.calls = append(hl.calls, call)
+}
+
+func (hl *hookLog) hooks() Hooks {
+	return Hooks{
+		OnRegister: func(fd int) { hl.record(fmt.Sprint("register ", fd)) },
+		OnNotify:   func(fd int, reason error) { hl.record(fmt.Sprint("notify ", fd)) },
+		OnRelease:  func(fd int, cause error) { hl.record(fmt.Sprint("release ", fd)) },
+		OnError: func(fd int, err error) {
+			hl.mu.Lock()
+			defer hl.mu.Unlock()
+			hl.errs = append(hl.errs, err)
+		},
+	}
+}
+
+// wait returns the calls once there are n of them, failing the test after a second.
+func (hl *hookLog) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		hl.mu.Lock()
+		calls := append([]string(nil), hl.calls...)
+		hl.mu.Unlock()
+		if len(calls) >= n {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d calls, got %v", n, calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	hb, ok := be.(HookedBackend)
+	if !ok {
+		t.Skip("backend does not call hooks")
+	}
+	backendLog := &hookLog{}
+	hb.SetHooks(backendLog.hooks())
+	frontendLog := &hookLog{}
+	fe := WithBackend(be)
+	fe.SetHooks(frontendLog.hooks())
+
+	// A watch that fires.
+	client, server := tcpPair(t)
+	done := fe.Done(server)
+	client.Close()
+	waitDone(t, done)
+
+	calls := backendLog.wait(t, 3)
+	fd := calls[0][len("register "):]
+	if want := []string{"register " + fd, "notify " + fd, "release " + fd}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got %v", want, calls)
+	}
+
+	// A watch that is released.
+	_, released := tcpPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	fe.WithContext(ctx, released)
+	cancel()
+
+	calls = backendLog.wait(t, 5)[3:]
+	fd = calls[0][len("register "):]
+	if want := []string{"register " + fd, "release " + fd}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got %v", want, calls)
+	}
+
+	// A conn that cannot be watched.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	w.Close()
+	if fe.Done(r) != nil {
+		t.Fatal("expected nil for an unwatchable conn")
+	}
+
+	frontendLog.mu.Lock()
+	defer frontendLog.mu.Unlock()
+	if len(frontendLog.calls) != 2 || len(frontendLog.errs) != 1 {
+		t.Fatalf("expected two registrations and an error from the frontend, got %v and %v", frontendLog.calls, frontendLog.errs)
+	}
+}
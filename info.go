@@ -0,0 +1,89 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Info describes the connection watched by a Context from WithContext.
+type Info struct {
+	LocalAddr  net.Addr // nil if the conn does not report addresses
+	RemoteAddr net.Addr
+	Registered time.Time
+	// Closed reports whether the connection has been noticed closing, at ClosedAt, with Cause,
+	// which matches [ErrConnClosed]. A watch released because its parent Context ended, or ended
+	// with the connection still open (refused by a full or closed backend, or stalled), is not
+	// closed.
+	Closed   bool
+	ClosedAt time.Time
+	Cause    error
+}
+
+type infoKey struct{}
+
+// connInfo is the Info stored in a Context, filled in as the watch fires.
+type connInfo struct {
+	local, remote net.Addr
+	registered    time.Time
+
+	mu       sync.Mutex
+	closedAt time.Time
+	cause    error
+}
+
+func newConnInfo(conn Conn) *connInfo {
+	info := &connInfo{registered: time.Now()}
+
+	type addrs interface {
+		LocalAddr() net.Addr
+		RemoteAddr() net.Addr
+	}
+	if a, ok := conn.(addrs); ok {
+		info.local, info.remote = a.LocalAddr(), a.RemoteAddr()
+	}
+	return info
+}
+
+func (ci *connInfo) closed(cause error) {
+	if !errors.Is(cause, ErrConnClosed) {
+		return
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.closedAt = time.Now()
+	ci.cause = cause
+}
+
+// FromContext returns the Info for the connection ctx watches, if ctx is, or derives from, a
+// Context returned by WithContext. With several, the innermost wins.
+func FromContext(ctx context.Context) (Info, bool) {
+	ci, ok := ctx.Value(infoKey{}).(*connInfo)
+	if !ok {
+		return Info{}, false
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return Info{
+		LocalAddr:  ci.local,
+		RemoteAddr: ci.remote,
+		Registered: ci.registered,
+		Closed:     ci.cause != nil,
+		ClosedAt:   ci.closedAt,
+		Cause:      ci.cause,
+	}, true
+}
+
+// ClosedCause returns the cause recorded when the connection ctx watches closed, or nil if it has
+// not. Unlike [context.Cause], it finds the disconnect even from a child Context that was canceled
+// for another reason, or canceled first.
+func ClosedCause(ctx context.Context) error {
+	info, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return info.Cause
+}
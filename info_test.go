@@ -0,0 +1,86 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("expected no Info from a plain Context")
+	}
+
+	client, server := tcpPair(t)
+	ctx := WithContext(context.Background(), server)
+
+	info, ok := FromContext(ctx)
+	if !ok {
+		t.Fatal("expected Info")
+	}
+	if info.LocalAddr.String() != server.LocalAddr().String() || info.RemoteAddr.String() != server.RemoteAddr().String() {
+		t.Fatalf("expected %v -> %v, got %v -> %v", server.LocalAddr(), server.RemoteAddr(), info.LocalAddr, info.RemoteAddr)
+	}
+	if info.Registered.IsZero() || info.Closed || info.Cause != nil {
+		t.Fatalf("unexpected %+v", info)
+	}
+
+	// A child canceled for its own reason, before the disconnect.
+	child, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := ClosedCause(child); err != nil {
+		t.Fatalf("expected no closed cause yet, got %v", err)
+	}
+
+	client.Close()
+	waitDone(t, ctx.Done())
+
+	if err := ClosedCause(child); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed through the child, got %v", err)
+	}
+	if cause := context.Cause(child); cause != context.Canceled {
+		t.Fatalf("expected the child's own cause to be unchanged, got %v", cause)
+	}
+	info, _ = FromContext(child)
+	if !info.Closed || info.ClosedAt.Before(info.Registered) {
+		t.Fatalf("unexpected %+v", info)
+	}
+}
+
+// TestFromContextNotClosed checks that a watch that ends with the connection still open is not
+// recorded as a close.
+func TestFromContextNotClosed(t *testing.T) {
+	full := NewDefaultBackend()
+	defer full.Close()
+	full.(LimitedBackend).SetMaxWatches(1)
+	_, held := tcpPair(t)
+	WithBackend(full).Done(held)
+
+	closed := NewDefaultBackend()
+	closed.Close()
+
+	for _, tc := range []struct {
+		name     string
+		backend  Backend
+		expected error
+	}{
+		{"refused", full, ErrTooManyWatches},
+		{"backend closed", closed, ErrBackendClosed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, server := tcpPair(t)
+			ctx := WithBackend(tc.backend).WithContext(context.Background(), server)
+			waitDone(t, ctx.Done())
+			if cause := context.Cause(ctx); !errors.Is(cause, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, cause)
+			}
+
+			if err := ClosedCause(ctx); err != nil {
+				t.Fatalf("expected no closed cause, got %v", err)
+			}
+			if info, _ := FromContext(ctx); info.Closed || info.Cause != nil {
+				t.Fatalf("unexpected %+v", info)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWatches tracks registrations for regular files, which epoll(7) refuses with EPERM.
+// inotify(7) reports IN_CLOSE_WRITE/IN_CLOSE_NOWRITE when any process closes the file, so these
+// registrations are keyed by watch descriptor rather than by file descriptor.
+type inotifyWatches struct {
+	mu sync.Mutex
+	m  map[int][]*closeMapPayload // watch descriptor -> waiting registrations
+
+	publish func(payload *closeMapPayload) // called as each registration fires
+}
+
+func (iw *inotifyWatches) add(wd int, payload *closeMapPayload) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.m == nil {
+		iw.m = make(map[int][]*closeMapPayload)
+	}
+	iw.m[wd] = append(iw.m[wd], payload)
+}
+
+// remove takes payload out of wd's list, reporting whether it was there and whether the list is
+// now empty.
+func (iw *inotifyWatches) remove(wd int, payload *closeMapPayload) (removed, empty bool) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	payloads := iw.m[wd]
+	for i, p := range payloads {
+		if p == payload {
+			payloads = append(payloads[:i], payloads[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	if len(payloads) == 0 {
+		delete(iw.m, wd)
+		return removed, true
+	}
+	iw.m[wd] = payloads
+	return removed, false
+}
+
+func (iw *inotifyWatches) close(wd int) (count int) {
+	iw.mu.Lock()
+	payloads := iw.m[wd]
+	delete(iw.m, wd)
+	iw.mu.Unlock()
+
+	for _, payload := range payloads {
+		payload.cause = &CloseError{}
+		close(payload.c)
+		iw.publish(payload)
+		count++
+	}
+	return count
+}
+
+func (iw *inotifyWatches) len() (count int) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	for _, payloads := range iw.m {
+		count += len(payloads)
+	}
+	return count
+}
+
+func (iw *inotifyWatches) drain(cause error) (count int) {
+	iw.mu.Lock()
+	m := iw.m
+	iw.m = nil
+	iw.mu.Unlock()
+
+	for _, payloads := range m {
+		for _, payload := range payloads {
+			payload.cause = cause
+			close(payload.c)
+			iw.publish(payload)
+			count++
+		}
+	}
+	return count
+}
+
+func (ep *Epoll) startInotify() error {
+	inotifyFD, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("unix.InotifyInit1(): %w", err)
+	}
+
+RETRY:
+	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_ADD, inotifyFD, &unix.EpollEvent{
+		Events: unix.EPOLLIN,
+		Fd:     int32(inotifyFD),
+	}); errors.Is(err, unix.EINTR) {
+		ep.logger.Print("startInotify unix.EpollCtl EINTR")
+		goto RETRY
+	} else if err != nil {
+		unix.Close(inotifyFD)
+		return fmt.Errorf("unix.EpollCtl(): %w", err)
+	}
+
+	ep.inotifyFD = inotifyFD
+	return nil
+}
+
+// doneFile watches a regular file through its /proc/self/fd link, which inotify resolves to the
+// open inode even if the file has been renamed or unlinked. The dup'd fd is closed straight away:
+// it shares the caller's open file description, so holding it would keep the file from ever being
+// released and no close event would arrive. A borrowed fd is left alone.
+func (ep *Epoll) doneFile(fd int, borrowed bool) *closeMapPayload {
+	defer ep.closeOwned(fd, borrowed)
+
+	path := fmt.Sprintf("/proc/self/fd/%d", fd)
+	wd, err := unix.InotifyAddWatch(ep.inotifyFD, path, unix.IN_CLOSE_WRITE|unix.IN_CLOSE_NOWRITE|unix.IN_ONESHOT)
+	if err != nil {
+		ep.logger.Printf("unix.InotifyAddWatch(%s): %v", path, err)
+		return nil
+	}
+
+	ep.logger.Printf("Done(): added file %d as watch %d", fd, wd)
+
+	payload := &closeMapPayload{
+		c:          make(chan struct{}),
+		fd:         -1, // closed below
+		registered: time.Now(),
+	}
+	payload.release = func(cause error) bool {
+		removed, empty := ep.files.remove(wd, payload)
+		if !removed {
+			return false
+		}
+		if empty {
+			// Fails harmlessly if the watch fired in the meantime.
+			unix.InotifyRmWatch(ep.inotifyFD, uint32(wd))
+		}
+		payload.cause = cause
+		close(payload.c)
+		ep.files.publish(payload)
+		return true
+	}
+	ep.files.add(wd, payload)
+
+	return payload
+}
+
+// readInotify consumes all pending inotify events.
+func (ep *Epoll) readInotify() {
+	var buf [4096]byte
+	for {
+		n, err := unix.Read(ep.inotifyFD, buf[:])
+		if errors.Is(err, unix.EINTR) {
+			continue
+		} else if errors.Is(err, unix.EAGAIN) {
+			return
+		} else if err != nil {
+			ep.logger.Printf("inotify unix.Read(): %v", err)
+			return
+		}
+		ep.inotifyEvents(buf[:n])
+	}
+}
+
+// inotifyEvents handles the events read into buf. IN_IGNORED is treated like a close: the watch is
+// gone (the filesystem was unmounted, say) and would otherwise never fire. IN_Q_OVERFLOW means
+// events were dropped, and any of them may have been a close; every pending watch fails with
+// ErrEventsLost rather than risk waiting forever.
+func (ep *Epoll) inotifyEvents(buf []byte) {
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		off += unix.SizeofInotifyEvent + int(ev.Len)
+
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			count := ep.files.drain(ErrEventsLost)
+			ep.logger.Printf("inotify queue overflow: failed %d", count)
+			continue
+		}
+		if ev.Mask&(unix.IN_CLOSE_WRITE|unix.IN_CLOSE_NOWRITE|unix.IN_IGNORED) == 0 {
+			continue
+		}
+		count := ep.files.close(int(ev.Wd))
+		ep.logger.Printf("inotify watch %d mask %#x: closed %d", ev.Wd, ev.Mask, count)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestInotifyOverflow checks that a queue overflow, which may have swallowed a close, fails the
+// pending file watches instead of leaving them waiting.
+func TestInotifyOverflow(t *testing.T) {
+	ep := NewEpoll()
+	defer ep.Close()
+	fe := WithBackend(ep)
+
+	f, err := os.CreateTemp(t.TempDir(), "overflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx := fe.WithContext(context.Background(), f)
+	assertNotDone(t, ctx.Done())
+
+	ev := unix.InotifyEvent{Wd: -1, Mask: unix.IN_Q_OVERFLOW}
+	ep.inotifyEvents(unsafe.Slice((*byte)(unsafe.Pointer(&ev)), unix.SizeofInotifyEvent))
+
+	waitDone(t, ctx.Done())
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrEventsLost) {
+		t.Fatalf("expected ErrEventsLost, got %v", cause)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func (o *WatchOptions) validateOS() error {
+	return nil
+}
+
+// apply sets opts on fd. They must have been validated.
+func (o *WatchOptions) apply(fd int) error {
+	if o.KeepAlive {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return fmt.Errorf("SO_KEEPALIVE: %w", err)
+		}
+	}
+	if o.KeepAliveIdle > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, seconds(o.KeepAliveIdle)); err != nil {
+			return fmt.Errorf("TCP_KEEPIDLE: %w", err)
+		}
+	}
+	if o.KeepAliveInterval > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, seconds(o.KeepAliveInterval)); err != nil {
+			return fmt.Errorf("TCP_KEEPINTVL: %w", err)
+		}
+	}
+	if o.KeepAliveCount > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, o.KeepAliveCount); err != nil {
+			return fmt.Errorf("TCP_KEEPCNT: %w", err)
+		}
+	}
+	if o.UserTimeout > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, millis(o.UserTimeout)); err != nil {
+			return fmt.Errorf("TCP_USER_TIMEOUT: %w", err)
+		}
+	}
+	return nil
+}
+
+// millis rounds d up to whole milliseconds, the unit of TCP_USER_TIMEOUT, in which 0 would mean
+// the system default.
+func millis(d time.Duration) int {
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func (o *WatchOptions) validateOS() error {
+	if o.UserTimeout > 0 {
+		return errors.New("UserTimeout is only supported on linux")
+	}
+	return nil
+}
+
+// apply sets opts on fd. They must have been validated.
+func (o *WatchOptions) apply(fd int) error {
+	if o.KeepAlive {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return fmt.Errorf("SO_KEEPALIVE: %w", err)
+		}
+	}
+	if o.KeepAliveIdle > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPALIVE, seconds(o.KeepAliveIdle)); err != nil {
+			return fmt.Errorf("TCP_KEEPALIVE: %w", err)
+		}
+	}
+	if o.KeepAliveInterval > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, seconds(o.KeepAliveInterval)); err != nil {
+			return fmt.Errorf("TCP_KEEPINTVL: %w", err)
+		}
+	}
+	if o.KeepAliveCount > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, o.KeepAliveCount); err != nil {
+			return fmt.Errorf("TCP_KEEPCNT: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func (o *WatchOptions) validateOS() error {
+	if o.tunesKeepAlive() || o.UserTimeout > 0 {
+		return errors.New("keepalive tuning is only supported on linux and darwin")
+	}
+	return nil
+}
+
+// apply sets opts on fd. They must have been validated.
+func (o *WatchOptions) apply(fd int) error {
+	if o.KeepAlive {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return fmt.Errorf("SO_KEEPALIVE: %w", err)
+		}
+	}
+	return nil
+}
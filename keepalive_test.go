@@ -0,0 +1,168 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWatchOptions(t *testing.T) {
+	fe, err := WithBackendOptions(DefaultBackend(), WatchOptions{
+		KeepAlive:         true,
+		KeepAliveIdle:     5 * time.Second,
+		KeepAliveInterval: 2 * time.Second,
+		KeepAliveCount:    3,
+		UserTimeout:       1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if err := server.SetKeepAlive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if fe.Done(server) == nil {
+		t.Fatal("expected channel")
+	}
+
+	sconn, err := server.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name       string
+		level, opt int
+		expected   int
+	}{
+		{"SO_KEEPALIVE", unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1},
+		{"TCP_KEEPIDLE", unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, 5},
+		{"TCP_KEEPINTVL", unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, 2},
+		{"TCP_KEEPCNT", unix.IPPROTO_TCP, unix.TCP_KEEPCNT, 3},
+		{"TCP_USER_TIMEOUT", unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, 1500},
+	} {
+		var (
+			got int
+			err error
+		)
+		if cerr := sconn.Control(func(fd uintptr) {
+			got, err = unix.GetsockoptInt(int(fd), tc.level, tc.opt)
+		}); cerr != nil {
+			t.Fatal(cerr)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, got)
+		}
+	}
+}
+
+// dropIncoming makes conn's host vanish as far as its peer can tell: a socket filter discards
+// every segment sent to it before TCP sees it, so nothing is acked, answered or reset.
+func dropIncoming(t *testing.T, conn *net.TCPConn) {
+	t.Helper()
+
+	sconn, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop := unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: 0}
+	if cerr := sconn.Control(func(fd uintptr) {
+		err = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{Len: 1, Filter: &drop})
+	}); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDeadPeer checks that a peer that vanished without a FIN or RST is reported once the tuned
+// kernel gives up on it, with the ETIMEDOUT it left in SO_ERROR as the cause.
+func TestDeadPeer(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  WatchOptions
+		write bool
+	}{
+		{"user timeout", WatchOptions{UserTimeout: 300 * time.Millisecond}, true},
+		{"keepalive", WatchOptions{KeepAlive: true, KeepAliveIdle: time.Second, KeepAliveInterval: time.Second, KeepAliveCount: 1}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := tcpPair(t)
+			fe, err := WithBackendOptions(DefaultBackend(), tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := fe.WithContext(context.Background(), server)
+			dropIncoming(t, client)
+			if tc.write {
+				if _, err := server.Write([]byte("x")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the kernel to give up on the peer")
+			}
+			var closeErr *CloseError
+			if cause := context.Cause(ctx); !errors.As(cause, &closeErr) || !errors.Is(closeErr.Err, unix.ETIMEDOUT) {
+				t.Fatalf("expected ETIMEDOUT, got %v", cause)
+			}
+			// Reading SO_ERROR consumed it; the caller's own read sees the connection shut.
+			if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("expected EOF from Read, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWatchOptionsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts WatchOptions
+	}{
+		{"idle without keepalive", WatchOptions{KeepAliveIdle: time.Second}},
+		{"count without keepalive", WatchOptions{KeepAliveCount: 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := WithBackendOptions(DefaultBackend(), tc.opts); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	if got := millis(100 * time.Microsecond); got != 1 {
+		t.Errorf("expected a sub-millisecond UserTimeout to round up to 1ms, got %d", got)
+	}
+}
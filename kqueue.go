@@ -3,11 +3,13 @@
 package blockuntilclosed
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"sync"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -44,6 +46,10 @@ type KQueue struct {
 	closeOnce           func() error
 	allDone             chan struct{}
 	m                   closeMap
+
+	mu     sync.RWMutex // held for reading while registering, so close can wait them out
+	closed bool
+	limit  *watchLimit
 }
 
 // NewKQueue returns a new KQueue instance.
@@ -62,8 +68,10 @@ func NewKQueue() *KQueue {
 		allDone:   make(chan struct{}),
 		closeOnce: nil,
 		m:         closeMap{},
+		limit:     newWatchLimit(),
 	}
 	kq.closeOnce = sync.OnceValue(kq.close)
+	kq.m.beforeClose = kq.deregister
 
 	if err := kq.startKQueue(); err != nil {
 		logger.Fatalf("kq.startKQueue(): %v", err)
@@ -76,18 +84,47 @@ func (kq *KQueue) SetLogger(logger *log.Logger) {
 	kq.logger = logger
 }
 
+func (kq *KQueue) SetMaxWatches(n int) {
+	kq.limit.set(n)
+}
+
+func (kq *KQueue) Watches() (n, max int) {
+	return kq.m.Len(), kq.limit.get()
+}
+
+func (kq *KQueue) SetHooks(h Hooks) {
+	kq.m.hooks.set(h)
+}
+
+func (kq *KQueue) Subscribe(buffer int) (<-chan Event, func()) {
+	return kq.m.events.subscribe(buffer)
+}
+
 func (kq *KQueue) getMap() *closeMap {
 	return &kq.m
 }
 
+func (kq *KQueue) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, kq.stopAccepting, kq.m.Len, kq.closeOnce)
+}
+
+func (kq *KQueue) stopAccepting() {
+	kq.mu.Lock()
+	kq.closed = true
+	kq.mu.Unlock()
+}
+
 func (kq *KQueue) close() error {
+	kq.stopAccepting()
+
 	err := errors.Join(
 		kq.pipeRead.Close(),
 		kq.pipeWrite.Close(),
 	)
 	<-kq.allDone
-	count := kq.m.Drain()
+	count := kq.m.Drain(ErrBackendClosed)
 	kq.logger.Printf("Drain(): %d", count)
+	kq.m.events.close()
 	return err
 }
 
@@ -96,13 +133,45 @@ func (kq *KQueue) Close() error {
 }
 
 func (kq *KQueue) Done(fd int) <-chan struct{} {
-	select {
-	case <-kq.allDone:
-		return nil
-	default:
+	if payload := kq.register(fd, false); payload != nil {
+		return payload.c
 	}
+	return nil
+}
 
-	loaded, payload := kq.m.Add(fd)
+// Register is like Done, and also reports why the file descriptor was reported closed.
+func (kq *KQueue) Register(fd int) Registration {
+	if payload := kq.register(fd, false); payload != nil {
+		return payload
+	}
+	return nil
+}
+
+// Borrow is like Register, but fd remains the caller's: it is not closed once the registration
+// ends, and must stay open until then.
+func (kq *KQueue) Borrow(fd int) Registration {
+	if payload := kq.register(fd, true); payload != nil {
+		return payload
+	}
+	return nil
+}
+
+// register watches fd, closing it once the registration ends unless it is borrowed.
+func (kq *KQueue) register(fd int, borrowed bool) *closeMapPayload {
+	kq.mu.RLock()
+	defer kq.mu.RUnlock()
+	if kq.closed {
+		kq.closeOwned(fd, borrowed)
+		return closedPayload(ErrBackendClosed)
+	}
+
+	if kq.limit.full(kq.m.Len()) {
+		kq.logger.Printf("Done(): too many watches (%d)", kq.limit.get())
+		kq.closeOwned(fd, borrowed)
+		return closedPayload(ErrTooManyWatches)
+	}
+
+	loaded, payload := kq.m.Add(fd, borrowed)
 	if payload == nil {
 		kq.logger.Print("nil payload; this is a problem")
 		return nil
@@ -116,7 +185,7 @@ func (kq *KQueue) Done(fd int) <-chan struct{} {
 	if loaded {
 		// Already added
 		kq.logger.Print("Already added")
-		return payload.c
+		return payload
 	}
 
 	eventsIn := [...]unix.Kevent_t{
@@ -132,7 +201,7 @@ func (kq *KQueue) Done(fd int) <-chan struct{} {
 				unix.EV_RECEIPT,
 			Fflags: unix.NOTE_NONE,
 			Data:   0,
-			Udata:  nil,
+			Udata:  payloadUdata(payload),
 		},
 	}
 
@@ -145,12 +214,41 @@ RETRY:
 		goto RETRY
 	} else if err != nil {
 		kq.logger.Printf("Done unix.Kevent(): %v", err)
+		kq.m.hooks.fail(fd, err)
+		kq.m.Close(payload.token, err)
 		return nil
 	}
 
 	kq.logger.Print("Done success")
 
-	return payload.c
+	return payload
+}
+
+// deregister removes fd's knote. Closing fd would drop it anyway, but a borrowed fd stays open.
+func (kq *KQueue) deregister(fd int) {
+	select {
+	case <-kq.allDone:
+		return // kqfd is closed, and its number may have been reused
+	default:
+	}
+	changes := [...]unix.Kevent_t{{
+		Ident:  uint64(fd),
+		Filter: unix.EVFILT_EXCEPT,
+		Flags:  unix.EV_DELETE,
+	}}
+	if _, err := unix.Kevent(kq.kqfd, changes[:], nil, nil); err != nil && !errors.Is(err, unix.ENOENT) {
+		kq.logger.Printf("deregister unix.Kevent(%d): %v", fd, err)
+	}
+}
+
+// closeOwned closes an fd that was handed over, when it will not be registered after all.
+func (kq *KQueue) closeOwned(fd int, borrowed bool) {
+	if borrowed {
+		return
+	}
+	if err := unix.Close(fd); err != nil {
+		kq.logger.Printf("unix.Close(%d): %v", fd, err)
+	}
 }
 
 func (kq *KQueue) startKQueue() error {
@@ -216,6 +314,7 @@ func (kq *KQueue) worker(cancelFD uintptr) {
 			goto RETRY
 		} else if err != nil {
 			kq.logger.Printf("poll unix.Kevent(): %v", err)
+			kq.m.hooks.fail(-1, err)
 			return
 		}
 
@@ -266,7 +365,34 @@ func (kq *KQueue) worker(cancelFD uintptr) {
 			continue
 		}
 
-		closed := kq.m.Close(int(ev.Ident))
-		kq.logger.Print("Close success=", closed)
+		kq.fire(udataPayload(ev.Udata))
 	}
 }
+
+// fire ends the registration that p was made for. Events are matched by the payload in udata, not
+// by ident: the event may have been read just before a concurrent Release deleted its knote and
+// closed the descriptor, whose number a new registration then got. p is then no longer in the map.
+func (kq *KQueue) fire(p *closeMapPayload) bool {
+	payload, ok := kq.m.take(p.token)
+	if !ok {
+		kq.logger.Printf("stale event for token %#x", p.token)
+		return false
+	}
+	cause := &CloseError{}
+	kq.m.hooks.notify(payload.fd, cause)
+	kq.m.finish(payload, cause)
+	kq.logger.Printf("Close(%d)", payload.fd)
+	return true
+}
+
+// payloadUdata carries a registration's payload in a kevent's udata, which the kernel hands back
+// untouched. The kernel's copy is invisible to the garbage collector, but the map holds the payload
+// until its knote is deleted, and an event already read is a pointer the collector does see.
+func payloadUdata(payload *closeMapPayload) *byte {
+	return (*byte)(unsafe.Pointer(payload))
+}
+
+// udataPayload recovers the payload stored by payloadUdata.
+func udataPayload(udata *byte) *closeMapPayload {
+	return (*closeMapPayload)(unsafe.Pointer(udata))
+}
@@ -0,0 +1,16 @@
+//go:build freebsd || openbsd || netbsd || dragonfly || darwin
+
+package blockuntilclosed_test
+
+import (
+	"testing"
+
+	"jonwillia.ms/blockuntilclosed"
+	"jonwillia.ms/blockuntilclosed/backendtest"
+)
+
+func TestKQueueConformance(t *testing.T) {
+	backendtest.Run(t, func() blockuntilclosed.Backend {
+		return blockuntilclosed.NewKQueue()
+	})
+}
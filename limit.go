@@ -0,0 +1,45 @@
+package blockuntilclosed
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultWatchFraction is the share of RLIMIT_NOFILE that registrations may use by default,
+// leaving the rest for the connections themselves and everything else in the process.
+const defaultWatchFraction = 4
+
+// watchLimit implements the cap behind LimitedBackend. It is checked before registering, so
+// concurrent registrations may overshoot it slightly.
+type watchLimit struct {
+	max atomic.Int64
+}
+
+func newWatchLimit() *watchLimit {
+	wl := &watchLimit{}
+	wl.max.Store(defaultMaxWatches())
+	return wl
+}
+
+func defaultMaxWatches() int64 {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil || rlim.Cur == unix.RLIM_INFINITY {
+		return 0
+	}
+	return int64(rlim.Cur / defaultWatchFraction)
+}
+
+func (wl *watchLimit) set(n int) {
+	wl.max.Store(int64(n))
+}
+
+func (wl *watchLimit) get() int {
+	return int(wl.max.Load())
+}
+
+// full reports whether n pending registrations have reached the cap.
+func (wl *watchLimit) full(n int) bool {
+	max := wl.max.Load()
+	return max > 0 && int64(n) >= max
+}
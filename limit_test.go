@@ -0,0 +1,54 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMaxWatches(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	lb, ok := be.(LimitedBackend)
+	if !ok {
+		t.Skip("backend does not support limits")
+	}
+	fe := WithBackend(be)
+
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		t.Fatal(err)
+	}
+	if _, max := lb.Watches(); rlim.Cur != unix.RLIM_INFINITY && max != int(rlim.Cur/defaultWatchFraction) {
+		t.Fatalf("expected default cap of %d, got %d", rlim.Cur/defaultWatchFraction, max)
+	}
+
+	lb.SetMaxWatches(1)
+
+	client, server := tcpPair(t)
+	first := fe.WithContext(context.Background(), server)
+	assertNotDone(t, first.Done())
+
+	if n, max := lb.Watches(); n != 1 || max != 1 {
+		t.Fatalf("expected 1/1 watches, got %d/%d", n, max)
+	}
+
+	_, other := tcpPair(t)
+	refused := fe.WithContext(context.Background(), other)
+	waitDone(t, refused.Done())
+	if cause := context.Cause(refused); !errors.Is(cause, ErrTooManyWatches) {
+		t.Fatalf("expected ErrTooManyWatches, got %v", cause)
+	}
+
+	client.Close()
+	waitDone(t, first.Done())
+
+	if n, _ := lb.Watches(); n != 0 {
+		t.Fatalf("expected no watches, got %d", n)
+	}
+
+	accepted := fe.Done(other)
+	assertNotDone(t, accepted)
+}
@@ -13,9 +13,20 @@ var _ syscall.Conn = (*net.TCPConn)(nil)
 
 // package scope errors may extracted from canceled contexts using [context.Cause].
 var (
-	ErrConnClosed = errors.New("conn closed")
+	ErrConnClosed    = errors.New("conn closed")
+	ErrProcessExited = errors.New("process exited")
+	// ErrBackendClosed is the cause for registrations made after, or still pending when, the
+	// backend was closed. It is deliberately distinct from ErrConnClosed.
+	ErrBackendClosed = errors.New("backend closed")
+	// ErrTooManyWatches is the cause for registrations refused because the backend is at its cap.
+	ErrTooManyWatches = errors.New("too many watches")
+	// ErrEventsLost is the cause for registrations ended because the kernel dropped notifications
+	// (an inotify queue overflow, say), so that a close may have gone unreported.
+	ErrEventsLost = errors.New("notifications lost")
 )
 
+// Conn is a connection or file to watch. Use [Unwrap] to find the Conn behind a wrapper that is not
+// one itself, such as a [*crypto/tls.Conn].
 type Conn interface {
 	syscall.Conn
 	// net.Conn // TODO: This should be constrained on mac because we don't know how to do this for os.File.
@@ -27,6 +38,46 @@ func Done(conn Conn) <-chan struct{} {
 }
 
 // WithContext returns a wrapped Context that is canceled when the file descriptor is closed.
+// The cause matches [ErrConnClosed]; backends that know more report a [*CloseError].
+// [FromContext] recovers the connection's [Info] from it, or any Context derived from it.
 func WithContext(ctx context.Context, conn Conn) context.Context {
 	return DefaultFrontend().WithContext(ctx, conn)
 }
+
+// DoneFD is like Done, for a bare file descriptor, such as one received over SCM_RIGHTS or from
+// cgo. opts say whether fd is dup'd or borrowed; either way its blocking mode is left alone.
+func DoneFD(fd int, opts FDOptions) <-chan struct{} {
+	return DefaultFrontend().DoneFD(fd, opts)
+}
+
+// WithContextFD is like WithContext, for a bare file descriptor. See [DoneFD]. The returned
+// CancelFunc releases the watch before it returns, then cancels the Context; call it once the
+// Context is no longer needed, and before closing a borrowed fd.
+func WithContextFD(ctx context.Context, fd int, opts FDOptions) (context.Context, context.CancelFunc) {
+	return DefaultFrontend().WithContextFD(ctx, fd, opts)
+}
+
+// WithContextAny returns a wrapped Context that is canceled when any of conns closes. The cause is
+// a [*ConnError] naming which. The conns are waited on together, rather than by a goroutine each.
+// Conns that cannot be watched are left out: the Context is canceled when any of the others closes.
+func WithContextAny(ctx context.Context, conns ...Conn) context.Context {
+	return DefaultFrontend().WithContextAny(ctx, conns...)
+}
+
+// WithContextAll returns a wrapped Context that is canceled once every one of conns has closed.
+// The cause joins a [*ConnError] for each. If any of conns cannot be watched, it cannot be known to
+// have closed, so ctx is returned as is, as WithContext does for a single conn that cannot be watched.
+func WithContextAll(ctx context.Context, conns ...Conn) context.Context {
+	return DefaultFrontend().WithContextAll(ctx, conns...)
+}
+
+// DoneProcess blocks until a process exits. It fires at once if p has already been waited for.
+func DoneProcess(p *os.Process) <-chan struct{} {
+	return DefaultFrontend().DoneProcess(p)
+}
+
+// WithProcessContext returns a wrapped Context that is canceled when the process exits.
+// The cause is a [*ProcessExitError].
+func WithProcessContext(ctx context.Context, p *os.Process) context.Context {
+	return DefaultFrontend().WithProcessContext(ctx, p)
+}
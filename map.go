@@ -1,61 +1,209 @@
 package blockuntilclosed
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
+// firstToken is the first token handed out by closeMap. Tokens below it are free for a backend's
+// own descriptors (its cancel pipe, say), which it may register with the kernel by number.
+const firstToken = 1 << 32
+
+// closeMap holds pending registrations by token. Backends hand the kernel the token rather than
+// the descriptor number (in epoll_event.data, for example): once a dup'd descriptor is closed its
+// number can be reused by a new registration before a stale event for the old one is processed,
+// and that event must not fire the new registration.
 type closeMap struct {
-	m sync.Map // map[int] *closeMapPayload
+	mu   sync.Mutex
+	m    map[uint64]*closeMapPayload // by token
+	byFD map[int]uint64              // token by descriptor, to dedupe registrations
+	next uint64
+	// beforeClose, if set, is called with each descriptor just before it is closed.
+	beforeClose func(fd int)
+	events      eventHub
+	hooks       hookSet
 }
 
 type closeMapPayload struct {
-	c chan struct{}
+	c        chan struct{}
+	cause    error // written before c is closed
+	fd       int
+	token    uint64
+	pid      int  // set when fd is a pidfd
+	sotype   int  // SO_TYPE when fd is a socket
+	borrowed bool // fd belongs to the caller; deregister it but do not close it
+	release  func(cause error) bool
+
+	// For events; see describe.
+	registered            time.Time
+	described             bool
+	cookie                uint64
+	localAddr, remoteAddr net.Addr
+}
+
+// closedPayload returns a registration that has already fired with cause.
+func closedPayload(cause error) *closeMapPayload {
+	payload := &closeMapPayload{
+		c:     make(chan struct{}),
+		cause: cause,
+	}
+	close(payload.c)
+	return payload
+}
+
+func (p *closeMapPayload) Done() <-chan struct{} {
+	return p.c
+}
+
+func (p *closeMapPayload) Err() error {
+	select {
+	case <-p.c:
+		return p.cause
+	default:
+		return nil
+	}
+}
+
+func (p *closeMapPayload) Release(cause error) bool {
+	if p.release == nil {
+		return false
+	}
+	return p.release(cause)
 }
 
-func (cm *closeMap) Close(key int) bool {
-	v, loaded := cm.m.LoadAndDelete(key)
-	if !loaded {
+// Close fires the registration for token with cause, and closes its descriptor. It reports false
+// if there is no such registration, because it already fired or the event is stale.
+func (cm *closeMap) Close(token uint64, cause error) bool {
+	payload, ok := cm.take(token)
+	if !ok {
 		return false
 	}
-	if payload, ok := v.(*closeMapPayload); ok {
-		close(payload.c)
+	cm.finish(payload, cause)
+	return true
+}
 
-		err := unix.Close(key) // Close the dup'd file descriptor
-		if err != nil {
-			log.Printf("unix.Close(%d): %v", key, err) // TODO: inject logger
+// take removes the registration for token, so that nothing else can fire it or close its
+// descriptor. A backend takes a payload before reading the cause from its descriptor, then hands
+// it to finish: were it only loaded, a concurrent Release could close the descriptor and let its
+// number be reused in between.
+func (cm *closeMap) take(token uint64) (*closeMapPayload, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	payload, ok := cm.m[token]
+	if ok {
+		delete(cm.m, token)
+		if cm.byFD[payload.fd] == token {
+			delete(cm.byFD, payload.fd)
 		}
+	}
+	return payload, ok
+}
+
+// finish fires a payload removed by take with cause, and closes its descriptor. The descriptor
+// is deregistered before Done is closed: a borrowed one may be closed, and its number reused, as
+// soon as the caller sees Done.
+func (cm *closeMap) finish(payload *closeMapPayload, cause error) {
+	if cm.beforeClose != nil {
+		cm.beforeClose(payload.fd)
+	}
+	payload.cause = cause
+	cm.publish(payload)
+	cm.hooks.release(payload.fd, cause)
+	close(payload.c)
 
-		return true
+	if payload.borrowed {
+		return
+	}
+	err := unix.Close(payload.fd) // Close the dup'd file descriptor
+	if err != nil {
+		log.Printf("unix.Close(%d): %v", payload.fd, err) // TODO: inject logger
 	}
-	return false // May have already been closed. But how?
 }
 
-func (cm *closeMap) Add(key int) (loaded bool, _ *closeMapPayload) {
-	c := make(chan struct{})
-	payload := &closeMapPayload{
-		c: c,
+func (cm *closeMap) Add(fd int, borrowed bool) (loaded bool, _ *closeMapPayload) {
+	return cm.add(fd, &closeMapPayload{
+		c:        make(chan struct{}),
+		borrowed: borrowed,
+	})
+}
+
+// add registers payload for fd. If loaded is set, the returned payload is not a new registration,
+// and the caller must not hand it to the kernel: it is an owned registration of the same number,
+// shared, or, for a borrowed fd already watched, one that has fired with EEXIST. A borrowed
+// registration is never shared, since releasing one would end the other.
+func (cm *closeMap) add(fd int, payload *closeMapPayload) (loaded bool, _ *closeMapPayload) {
+	payload.registered = time.Now()
+	if cm.events.active() {
+		payload.describe(fd) // the peer may be gone by the time it fires
+	}
+
+	cm.mu.Lock()
+	if token, ok := cm.byFD[fd]; ok {
+		existing := cm.m[token]
+		cm.mu.Unlock()
+		if payload.borrowed || existing.borrowed {
+			return true, closedPayload(fmt.Errorf("fd %d is already watched: %w", fd, unix.EEXIST))
+		}
+		return true, existing
+	}
+
+	if cm.m == nil {
+		cm.m = make(map[uint64]*closeMapPayload)
+		cm.byFD = make(map[int]uint64)
+		cm.next = firstToken
 	}
-	v, loaded := cm.m.LoadOrStore(key, payload)
-	if !loaded {
-		return false, payload
+	token := cm.next
+	cm.next++
+
+	payload.fd = fd
+	payload.token = token
+	payload.release = func(cause error) bool {
+		return cm.Close(token, cause)
+	}
+	cm.m[token] = payload
+	cm.byFD[fd] = token
+	cm.mu.Unlock()
+
+	cm.hooks.register(fd)
+	return false, payload
+}
+
+// publish sends an event for a payload that has just fired, if anyone is subscribed. Payloads
+// without a descriptor of their own have fd -1.
+func (cm *closeMap) publish(payload *closeMapPayload) {
+	if !cm.events.active() {
+		return
 	}
-	if p, ok := v.(*closeMapPayload); ok {
-		return true, p
+	if payload.fd >= 0 {
+		payload.describe(payload.fd)
 	}
+	cm.events.publish(payload.event(time.Now()))
+}
 
-	return false, nil // This is an error
+// Len returns the number of registrations that have not fired yet.
+func (cm *closeMap) Len() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return len(cm.m)
 }
 
-func (cm *closeMap) Drain() (count int) {
-	cm.m.Range(func(key, value interface{}) bool {
-		closed := cm.Close(key.(int))
-		if closed {
+func (cm *closeMap) Drain(cause error) (count int) {
+	cm.mu.Lock()
+	tokens := make([]uint64, 0, len(cm.m))
+	for token := range cm.m {
+		tokens = append(tokens, token)
+	}
+	cm.mu.Unlock()
+
+	for _, token := range tokens {
+		if cm.Close(token, cause) {
 			count++
 		}
-		return true // continue
-	})
+	}
 	return count
 }
@@ -59,16 +59,17 @@ func TestMapDeduped(t *testing.T) {
 	}
 
 	if cNew := fe.Done(sock); c != cNew {
-		t.Log("expected same channel") // when we added dup'd fd, we no longer get the same channel.
+		if haveSocketCookie {
+			t.Fatal("expected same channel")
+		}
+		t.Log("expected same channel") // without socket cookies, every call registers a new dup'd fd.
 	}
 
-	var count int
-	m.m.Range(func(key, value any) bool {
-		count++
-		return true
-	})
-	if count != 1 {
-		t.Logf("expected one entry, got %d", count) // when we added dup'd fd, we no longer get the same channel.
+	if count := m.Len(); count != 1 {
+		if haveSocketCookie {
+			t.Fatalf("expected one entry, got %d", count)
+		}
+		t.Logf("expected one entry, got %d", count) // without socket cookies, every call registers a new dup'd fd.
 	}
 
 	select {
@@ -88,12 +89,7 @@ func TestMapDeduped(t *testing.T) {
 		t.Fatal("expected close")
 	}
 
-	count = 0
-	m.m.Range(func(key, value any) bool {
-		count++
-		return true
-	})
-	if count != 0 {
+	if count := m.Len(); count != 0 {
 		t.Fatalf("expected no entries, got %d", count)
 	}
 
@@ -0,0 +1,11 @@
+//go:build darwin
+
+package blockuntilclosed
+
+import "golang.org/x/sys/unix"
+
+// sendQueue returns the bytes in fd's send buffer, sent or not, that the peer has yet to
+// acknowledge (SO_NWRITE).
+func sendQueue(fd int) (int, error) {
+	return unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_NWRITE)
+}
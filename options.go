@@ -0,0 +1,59 @@
+package blockuntilclosed
+
+import (
+	"errors"
+	"time"
+)
+
+// WatchOptions configure how a Frontend prepares the sockets it watches. They are applied to the
+// socket at registration time. The zero value leaves sockets untouched.
+//
+// Done only fires on a FIN, a reset or an error, so a peer that silently vanished (a laptop lid
+// closed, an expired NAT entry) is never noticed with the default 2 hour keepalive. Tuning these
+// makes the kernel give up on such a peer, which is then reported with an ETIMEDOUT cause.
+type WatchOptions struct {
+	// KeepAlive enables SO_KEEPALIVE.
+	KeepAlive bool
+	// KeepAliveIdle, KeepAliveInterval and KeepAliveCount set TCP_KEEPIDLE (TCP_KEEPALIVE on
+	// macOS), TCP_KEEPINTVL and TCP_KEEPCNT, the durations rounded up to whole seconds. Zero keeps
+	// the system default. They require KeepAlive.
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+	// UserTimeout sets TCP_USER_TIMEOUT, how long sent data may remain unacknowledged before the
+	// connection is dropped. It is rounded up to whole milliseconds. Zero keeps the system
+	// default. Linux only.
+	UserTimeout time.Duration
+
+	// StallBytes and StallTimeout opt in to slow-consumer detection, for peers that stay connected
+	// but stop reading: once a socket's send queue (SIOCOUTQ on Linux, SO_NWRITE on macOS) has
+	// stayed above StallBytes for StallTimeout, its watch fires with a [*StallError] cause. Both
+	// must be set, and the backend must implement [Releaser], as the shipped ones do.
+	StallBytes   int
+	StallTimeout time.Duration
+}
+
+func (o *WatchOptions) isZero() bool {
+	return *o == WatchOptions{}
+}
+
+func (o *WatchOptions) stalls() bool {
+	return o.StallBytes > 0 && o.StallTimeout > 0
+}
+
+func (o *WatchOptions) tunesKeepAlive() bool {
+	return o.KeepAliveIdle > 0 || o.KeepAliveInterval > 0 || o.KeepAliveCount > 0
+}
+
+// validate rejects options that would otherwise be silently ignored.
+func (o *WatchOptions) validate() error {
+	if !o.KeepAlive && o.tunesKeepAlive() {
+		return errors.New("KeepAliveIdle, KeepAliveInterval and KeepAliveCount require KeepAlive")
+	}
+	return o.validateOS()
+}
+
+// seconds rounds d up to whole seconds, the unit of the keepalive socket options.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
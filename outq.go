@@ -0,0 +1,10 @@
+//go:build linux
+
+package blockuntilclosed
+
+import "golang.org/x/sys/unix"
+
+// sendQueue returns the bytes in fd's send queue that the peer has yet to acknowledge (SIOCOUTQ).
+func sendQueue(fd int) (int, error) {
+	return unix.IoctlGetInt(fd, unix.SIOCOUTQ)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package blockuntilclosed
+
+import "errors"
+
+func sendQueue(fd int) (int, error) {
+	return 0, errors.New("send queue size is not supported on this platform")
+}
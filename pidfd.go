@@ -0,0 +1,91 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// si_code values for SIGCHLD, which x/sys/unix does not export.
+const (
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
+
+// DoneProcess watches pid through a pidfd(2), which becomes readable when the process exits.
+// The pidfd is registered with the same epoll instance as sockets, so one worker serves both.
+func (ep *Epoll) DoneProcess(pid int) Registration {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.closed {
+		return closedPayload(ErrBackendClosed)
+	}
+	if ep.limit.full(ep.m.Len()) {
+		ep.logger.Printf("DoneProcess(): too many watches (%d)", ep.limit.get())
+		return closedPayload(ErrTooManyWatches)
+	}
+
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if errors.Is(err, unix.ESRCH) {
+		// Already exited and reaped.
+		return closedPayload(&ProcessExitError{Pid: pid})
+	} else if err != nil {
+		ep.logger.Printf("unix.PidfdOpen(%d): %v", pid, err)
+		ep.m.hooks.fail(-1, err)
+		return nil
+	}
+
+	_, payload := ep.m.add(pidfd, &closeMapPayload{
+		c:   make(chan struct{}),
+		pid: pid,
+	})
+
+RETRY:
+	if err := unix.EpollCtl(ep.epollFD, unix.EPOLL_CTL_ADD, pidfd, tokenEvent(unix.EPOLLIN|unix.EPOLLONESHOT, payload.token)); errors.Is(err, unix.EINTR) {
+		ep.logger.Print("DoneProcess unix.EpollCtl EINTR")
+		goto RETRY
+	} else if err != nil {
+		ep.logger.Printf("unix.EpollCtl(): %v", err)
+		ep.m.hooks.fail(pidfd, err)
+		ep.m.Close(payload.token, err)
+		return nil
+	}
+
+	ep.logger.Printf("DoneProcess(): added %d as %d", pid, pidfd)
+
+	return payload
+}
+
+// processExitCause peeks at the exit status of an exited process without reaping it.
+func processExitCause(pidfd, pid int) error {
+	cause := &ProcessExitError{Pid: pid}
+
+	var info unix.Siginfo
+	if err := unix.Waitid(unix.P_PIDFD, pidfd, &info, unix.WEXITED|unix.WNOWAIT|unix.WNOHANG, nil); err != nil {
+		return cause // ECHILD: not our child, or already reaped
+	}
+
+	status := sigchldStatus(&info)
+	switch info.Code {
+	case cldExited:
+		cause.Status, cause.HasStatus = syscall.WaitStatus(status<<8), true
+	case cldKilled:
+		cause.Status, cause.HasStatus = syscall.WaitStatus(status), true
+	case cldDumped:
+		cause.Status, cause.HasStatus = syscall.WaitStatus(status|0x80), true
+	}
+	return cause
+}
+
+// sigchldStatus reads si_status from a SIGCHLD siginfo_t, which x/sys/unix leaves opaque.
+// The union follows three ints, aligned to the pointer size; si_status follows si_pid and si_uid.
+func sigchldStatus(info *unix.Siginfo) uint32 {
+	const ptrSize = unsafe.Sizeof(uintptr(0))
+	off := (12+ptrSize-1)&^(ptrSize-1) + 8
+	return *(*uint32)(unsafe.Add(unsafe.Pointer(info), off))
+}
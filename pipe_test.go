@@ -0,0 +1,233 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestPipeReadEnd checks that the read end of a pipe fires once every writer has closed, and not
+// when data arrives.
+func TestPipeReadEnd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	done := Done(r)
+	if done == nil {
+		t.Fatal("expected channel")
+	}
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	assertNotDone(t, done)
+
+	w.Close()
+	waitDone(t, done)
+}
+
+// TestPipeWriteEnd checks that the write end of a pipe fires when its reader exits, as in `cmd | head`.
+func TestPipeWriteEnd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	cmd := exec.Command("head", "-c", "1")
+	cmd.Stdin = r
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close() // the child holds the only reader now
+
+	done := Done(w)
+	if done == nil {
+		t.Fatal("expected channel")
+	}
+	assertNotDone(t, done)
+
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+}
+
+// TestPipeWriteEndClosedFirst checks that a watch holds the pipe open until it is released: the
+// reader only gets its EOF once the caller has closed its write end and canceled the watch, as when
+// feeding `cat` its input.
+func TestPipeWriteEndClosedFirst(t *testing.T) {
+	be := NewEpoll()
+	defer be.Close()
+	fe := WithBackend(be)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("cat")
+	cmd.Stdin = r
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = fe.WithContext(ctx, w)
+
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		t.Fatalf("cat exited with %v while the watch held the write end", err)
+	case <-time.After(waitTime):
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("watch fired with %v after the caller closed its own end", context.Cause(ctx))
+	}
+
+	cancel()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		cmd.Process.Kill()
+		t.Fatal("cat did not see EOF after the watch was released")
+	}
+	waitWatches(t, be, 0)
+}
+
+// TestPipeDoneTwice checks that one pipe end can be watched twice, and that every watch is still
+// deregistered after the caller has closed its end: by a release, or by the other side closing.
+func TestPipeDoneTwice(t *testing.T) {
+	be := NewEpoll()
+	defer be.Close()
+	fe := WithBackend(be)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	done1 := fe.Done(r)
+	done2 := fe.Done(r)
+	if done1 == nil || done2 == nil {
+		t.Fatalf("expected two channels, got %v and %v", done1, done2)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = fe.WithContext(ctx, r)
+	if n, _ := be.Watches(); n != 3 {
+		t.Fatalf("expected 3 watches, got %d", n)
+	}
+
+	r.Close()
+	assertNotDone(t, done1)
+	assertNotDone(t, done2)
+
+	cancel()
+	<-ctx.Done()
+	waitWatches(t, be, 2)
+
+	w.Close()
+	waitDone(t, done1)
+	waitDone(t, done2)
+	waitWatches(t, be, 0)
+}
+
+// TestPipeWatchLimit checks that a released watch on a pipe end the caller has closed frees its
+// place under the cap.
+func TestPipeWatchLimit(t *testing.T) {
+	be := NewEpoll()
+	defer be.Close()
+	be.SetMaxWatches(1)
+	fe := WithBackend(be)
+
+	for i := 0; i < 3; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = fe.WithContext(ctx, r)
+		if ctx.Err() != nil {
+			t.Fatalf("watch %d refused: %v", i, context.Cause(ctx))
+		}
+		r.Close()
+		cancel()
+		waitWatches(t, be, 0)
+		w.Close()
+	}
+}
+
+// TestFIFO checks both directions on a named pipe.
+func TestFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := unix.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func() (r, w *os.File) {
+		r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err = os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, w
+	}
+
+	t.Run("read end", func(t *testing.T) {
+		r, w := open()
+		defer r.Close()
+
+		done := Done(r)
+		if done == nil {
+			t.Fatal("expected channel")
+		}
+		assertNotDone(t, done)
+
+		w.Close()
+		waitDone(t, done)
+	})
+
+	t.Run("write end", func(t *testing.T) {
+		r, w := open()
+		defer w.Close()
+
+		done := Done(w)
+		if done == nil {
+			t.Fatal("expected channel")
+		}
+		assertNotDone(t, done)
+
+		r.Close()
+		waitDone(t, done)
+	})
+}
@@ -0,0 +1,33 @@
+package blockuntilclosed
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// ProcessExitError is the cause of a context returned by WithProcessContext.
+// It matches [ErrProcessExited] with [errors.Is].
+type ProcessExitError struct {
+	Pid int
+	// Status is only valid if HasStatus is set. It can be read for children of this process that
+	// have not been reaped yet; the watch does not reap them, so [os.Process.Wait] still works.
+	Status    syscall.WaitStatus
+	HasStatus bool
+}
+
+func (e *ProcessExitError) Error() string {
+	if !e.HasStatus {
+		return fmt.Sprintf("process %d exited", e.Pid)
+	}
+	switch {
+	case e.Status.Exited():
+		return fmt.Sprintf("process %d exited with status %d", e.Pid, e.Status.ExitStatus())
+	case e.Status.Signaled():
+		return fmt.Sprintf("process %d killed by %v", e.Pid, e.Status.Signal())
+	}
+	return fmt.Sprintf("process %d exited", e.Pid)
+}
+
+func (e *ProcessExitError) Unwrap() error {
+	return ErrProcessExited
+}
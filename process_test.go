@@ -0,0 +1,86 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessExitStatus(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 0.1; exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithProcessContext(context.Background(), cmd.Process)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for process exit")
+	}
+
+	var exitErr *ProcessExitError
+	if cause := context.Cause(ctx); !errors.As(cause, &exitErr) {
+		t.Fatalf("expected ProcessExitError, got %v", cause)
+	}
+	if !errors.Is(exitErr, ErrProcessExited) {
+		t.Fatal("expected ErrProcessExited")
+	}
+	if !exitErr.HasStatus || !exitErr.Status.Exited() || exitErr.Status.ExitStatus() != 3 {
+		t.Fatalf("expected exit status 3, got %v", exitErr)
+	}
+	t.Log(exitErr)
+
+	// The watch must not have reaped the child.
+	var waitErr *exec.ExitError
+	if err := cmd.Wait(); !errors.As(err, &waitErr) || waitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3 from Wait, got %v", err)
+	}
+}
+
+func TestProcessKilled(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	ctx := WithProcessContext(context.Background(), cmd.Process)
+	done := DoneProcess(cmd.Process)
+	if done == nil {
+		t.Fatal("expected channel")
+	}
+	assertNotDone(t, done)
+
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+	<-ctx.Done()
+
+	var exitErr *ProcessExitError
+	if cause := context.Cause(ctx); !errors.As(cause, &exitErr) {
+		t.Fatalf("expected ProcessExitError, got %v", cause)
+	}
+	if !exitErr.HasStatus || !exitErr.Status.Signaled() || exitErr.Status.Signal() != syscall.SIGKILL {
+		t.Fatalf("expected SIGKILL, got %v", exitErr)
+	}
+}
+
+func TestProcessAlreadyReaped(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for the pid being reused: the watch must go by p having been waited for, not by
+	// whether some process has its pid.
+	cmd.Process.Pid = os.Getpid()
+	waitDone(t, DoneProcess(cmd.Process))
+}
@@ -0,0 +1,118 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// proxyGrace is how long Proxy waits, once a copy has failed, for the backend to report why.
+const proxyGrace = 100 * time.Millisecond
+
+// Proxy copies between downstream and upstream, watched through the default frontend, until both
+// directions are done, either side fails or resets, or ctx ends. Then it closes both. Unlike a
+// pair of io.Copy calls, it notices a reset even while the other side is silent, and tears that
+// side down at once. It returns the cause: a [*CloseError] matching [ErrConnClosed], or ctx's cause.
+//
+// A FIN is passed on with CloseWrite, not taken for a close: a client that calls
+// shutdown(SHUT_WR) after its request still gets the response. A side without a CloseWrite
+// method cannot be half-closed, so its peer's FIN tears both down. Both connections must also be
+// an [io.ReadWriteCloser], as a [*net.TCPConn] or [*os.File] is.
+func Proxy(ctx context.Context, downstream, upstream Conn) error {
+	down, ok := downstream.(io.ReadWriteCloser)
+	if !ok {
+		return fmt.Errorf("Proxy: %T is not an io.ReadWriteCloser", downstream)
+	}
+	up, ok := upstream.(io.ReadWriteCloser)
+	if !ok {
+		return fmt.Errorf("Proxy: %T is not an io.ReadWriteCloser", upstream)
+	}
+
+	fe := DefaultFrontend()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	watched, stopDown := withContextStop(fe, ctx, downstream)
+	watched, stopUp := withContextStop(fe, watched, upstream)
+
+	copied := make(chan error, 2)
+	go proxyCopy(up, down, copied)
+	go proxyCopy(down, up, copied)
+
+	var cause error
+	fired := watched.Done()
+	pending := 2
+	for cause == nil && pending > 0 {
+		select {
+		case <-fired:
+			fired = nil
+			if ctx.Err() != nil || !halfClosed(context.Cause(watched)) {
+				cause = context.Cause(watched)
+			}
+		case err := <-copied:
+			pending--
+			if err == nil {
+				continue
+			}
+			// A copy usually fails because a side reset, which the backend is about to report
+			// with more detail than the copy error has. Fall back to the copy error if it does not.
+			cause = &CloseError{Err: err}
+			if fired != nil {
+				timer := time.NewTimer(proxyGrace)
+				select {
+				case <-fired:
+					if c := context.Cause(watched); !halfClosed(c) {
+						cause = c
+					}
+				case <-timer.C:
+				}
+				timer.Stop()
+			}
+		}
+	}
+	if cause == nil {
+		// Both directions ended with a FIN that was passed on.
+		cause = context.Cause(watched)
+		if cause == nil {
+			cause = &CloseError{}
+		}
+	}
+
+	// Release the watches before the Closes below: their dup'd descriptors would keep the sockets
+	// open, and hold back the FIN, until then.
+	stopUp(cause)
+	stopDown(cause)
+	down.Close()
+	up.Close()
+	for ; pending > 0; pending-- {
+		<-copied
+	}
+
+	return cause
+}
+
+// proxyCopy copies src to dst, then passes src's EOF on to dst with CloseWrite. It sends nil once
+// it has, or the error that stopped it.
+func proxyCopy(dst, src io.ReadWriteCloser, copied chan<- error) {
+	// io.Copy hands *net.TCPConn pairs to ReadFrom/WriteTo, which splice(2) on Linux.
+	_, err := io.Copy(dst, src)
+	if err == nil {
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			err = cw.CloseWrite()
+		} else {
+			err = io.EOF
+		}
+	}
+	copied <- err
+}
+
+// halfClosed reports whether a watch fired because its peer sent a FIN, which Proxy leaves to the
+// copies to pass on, rather than because of a reset or an error.
+func halfClosed(cause error) bool {
+	var closeErr *CloseError
+	if !errors.As(cause, &closeErr) || closeErr.Err != nil {
+		return false
+	}
+	return closeErr.TCPInfo == nil || closeErr.TCPInfo.State == tcpCloseWait
+}
@@ -0,0 +1,151 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func waitProxy(t *testing.T, proxied <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-proxied:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Proxy did not return")
+		return nil
+	}
+}
+
+func TestProxy(t *testing.T) {
+	client, down := tcpPair(t)
+	up, server := tcpPair(t)
+
+	proxied := make(chan error, 1)
+	go func() {
+		proxied <- Proxy(context.Background(), down, up)
+	}()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("upstream read %q, %v", buf, err)
+	}
+	if _, err := server.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("downstream read %q, %v", buf, err)
+	}
+
+	// The upstream stays silent; the client leaving must still reach it at once.
+	client.Close()
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("expected upstream EOF, got %v", err)
+	}
+
+	server.Close()
+	err := waitProxy(t, proxied)
+	if !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected *CloseError, got %T", err)
+	}
+}
+
+// TestProxyHalfClose checks that a client that shuts down its write side after its request still
+// gets the response.
+func TestProxyHalfClose(t *testing.T) {
+	client, down := tcpPair(t)
+	up, server := tcpPair(t)
+
+	proxied := make(chan error, 1)
+	go func() {
+		proxied <- Proxy(context.Background(), down, up)
+	}()
+
+	if _, err := client.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	req, err := io.ReadAll(server)
+	if err != nil || string(req) != "request" {
+		t.Fatalf("upstream read %q, %v", req, err)
+	}
+	// Give the half-close time to be mistaken for a close.
+	time.Sleep(waitTime)
+	if _, err := server.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(client)
+	if err != nil || string(resp) != "response" {
+		t.Fatalf("downstream read %q, %v", resp, err)
+	}
+
+	if err := waitProxy(t, proxied); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+}
+
+// TestProxyReset checks that a client that resets tears down a silent upstream.
+func TestProxyReset(t *testing.T) {
+	client, down := tcpPair(t)
+	up, server := tcpPair(t)
+
+	proxied := make(chan error, 1)
+	go func() {
+		proxied <- Proxy(context.Background(), down, up)
+	}()
+
+	client.SetLinger(0)
+	client.Close()
+
+	if err := waitProxy(t, proxied); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected upstream EOF, got %v", err)
+	}
+}
+
+func TestProxyContext(t *testing.T) {
+	be := DefaultBackend().(LimitedBackend)
+	_, down := tcpPair(t)
+	up, server := tcpPair(t)
+
+	before, _ := be.Watches()
+	ctx, cancel := context.WithCancel(context.Background())
+	proxied := make(chan error, 1)
+	go func() {
+		proxied <- Proxy(ctx, down, up)
+	}()
+
+	cancel()
+	if err := waitProxy(t, proxied); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// The watches must be gone before Proxy closed the connections, not some time after.
+	if n, _ := be.Watches(); n > before {
+		t.Fatalf("expected at most %d watches once Proxy returned, got %d", before, n)
+	}
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected upstream EOF, got %v", err)
+	}
+}
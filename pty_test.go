@@ -0,0 +1,58 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a pseudo-terminal pair the way posix_openpt(3), unlockpt(3) and ptsname(3) do.
+func openPTY(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pty support: %v", err)
+	}
+
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		t.Fatalf("unlockpt: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		t.Fatalf("ptsname: %v", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	return master, slave
+}
+
+func TestPTYHangup(t *testing.T) {
+	master, slave := openPTY(t)
+	defer slave.Close()
+
+	done := Done(slave)
+	if done == nil {
+		master.Close()
+		t.Fatal("expected channel")
+	}
+
+	if _, err := master.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	assertNotDone(t, done)
+
+	master.Close()
+	waitDone(t, done)
+}
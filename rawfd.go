@@ -0,0 +1,55 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+)
+
+// FDOptions say who owns a bare descriptor passed to DoneFD or WithContextFD.
+type FDOptions struct {
+	// Borrow registers fd itself instead of a dup, for callers that cannot spare a descriptor. The
+	// backend never closes it, but the caller must keep it open until the watch ends: until Done
+	// is closed, or the CancelFunc from WithContextFD has returned. The Context being canceled is
+	// not enough, since a parent's cancellation releases the watch asynchronously. A descriptor can
+	// only be borrowed by one watch at a time; another fires at once with EEXIST. It needs a
+	// [BorrowBackend].
+	//
+	// By default a dup of fd is registered, and the caller may close fd whenever it likes.
+	Borrow bool
+}
+
+func (fe *frontend) DoneFD(fd int, opts FDOptions) <-chan struct{} {
+	reg := fe.registerRawFD(fd, opts)
+	if reg == nil {
+		return nil
+	}
+	return reg.Done()
+}
+
+func (fe *frontend) WithContextFD(ctx context.Context, fd int, opts FDOptions) (context.Context, context.CancelFunc) {
+	reg := fe.registerRawFD(fd, opts)
+	ctx, stop := withRegistration(ctx, reg, releaseRegistration(reg), nil)
+	return ctx, func() { stop(context.Canceled) }
+}
+
+// registerRawFD registers fd on its own: bare descriptors are not shared the way conns' watches
+// are, and are not polled for stalls.
+func (fe *frontend) registerRawFD(fd int, opts FDOptions) Registration {
+	if !opts.Borrow {
+		return fe.registerFD(fd)
+	}
+
+	bb, ok := fe.backend.(BorrowBackend)
+	if !ok {
+		fe.logger.Printf("backend %T cannot borrow descriptors", fe.backend)
+		fe.hooks.fail(fd, errors.New("backend cannot borrow descriptors"))
+		return nil
+	}
+	if !fe.opts.isZero() {
+		if err := fe.opts.apply(fd); err != nil {
+			fe.logger.Printf("WatchOptions: %v", err)
+		}
+	}
+	fe.hooks.register(fd)
+	return bb.Borrow(fd)
+}
@@ -0,0 +1,155 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketpair returns a connected pair of bare, blocking descriptors, closed when the test ends
+// unless the test closes them first.
+func socketpair(t *testing.T) (a, b int) {
+	t.Helper()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+	})
+	return fds[0], fds[1]
+}
+
+func TestDoneFD(t *testing.T) {
+	a, b := socketpair(t)
+
+	done := DoneFD(a, FDOptions{})
+	if done == nil {
+		t.Fatal("DoneFD returned nil")
+	}
+	// The frontend watches its own dup, so the caller may close its descriptor at once.
+	unix.Close(a)
+	assertNotDone(t, done)
+
+	unix.Close(b)
+	waitDone(t, done)
+}
+
+func TestDoneFDBorrow(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe := WithBackend(be)
+
+	a, b := socketpair(t)
+	done := fe.DoneFD(a, FDOptions{Borrow: true})
+	if done == nil {
+		t.Skip("backend cannot borrow descriptors")
+	}
+
+	unix.Close(b)
+	waitDone(t, done)
+
+	flags, err := unix.FcntlInt(uintptr(a), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatalf("expected the borrowed descriptor to stay open: %v", err)
+	}
+	if flags&unix.O_NONBLOCK != 0 {
+		t.Fatal("expected the borrowed descriptor's blocking mode to be left alone")
+	}
+}
+
+func TestWithContextFDBorrowRelease(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	if _, ok := be.(BorrowBackend); !ok {
+		t.Skip("backend cannot borrow descriptors")
+	}
+	fe := WithBackend(be)
+
+	a, b := socketpair(t)
+	ctx, cancel := fe.WithContextFD(context.Background(), a, FDOptions{Borrow: true})
+	cancel()
+	if ctx.Err() == nil {
+		t.Fatal("expected the Context to be canceled")
+	}
+	if n, _ := be.(LimitedBackend).Watches(); n != 0 {
+		t.Fatalf("expected the watch to be released once cancel returned, %d remain", n)
+	}
+	if _, err := unix.FcntlInt(uintptr(a), unix.F_GETFD, 0); err != nil {
+		t.Fatalf("expected the released descriptor to stay open: %v", err)
+	}
+
+	// A later registration of the same descriptor must not see the old one's event.
+	done := fe.DoneFD(a, FDOptions{Borrow: true})
+	assertNotDone(t, done)
+	unix.Close(b)
+	waitDone(t, done)
+}
+
+// TestBorrowReuse closes a borrowed descriptor as soon as its watch ends, as the caller may, and
+// borrows the number again straight away: the new watch must not be handed the old one's cause.
+func TestBorrowReuse(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	if _, ok := be.(BorrowBackend); !ok {
+		t.Skip("backend cannot borrow descriptors")
+	}
+	fe := WithBackend(be)
+
+	for i := 0; i < 200; i++ {
+		a, b := socketpair(t)
+		var done <-chan struct{}
+		if i%2 == 0 {
+			_, cancel := fe.WithContextFD(context.Background(), a, FDOptions{Borrow: true})
+			cancel()
+		} else {
+			done = fe.DoneFD(a, FDOptions{Borrow: true})
+			unix.Close(b)
+			waitDone(t, done)
+		}
+		unix.Close(a)
+		unix.Close(b)
+
+		c, d := socketpair(t)
+		if c != a {
+			t.Fatalf("iteration %d: expected descriptor %d to be reused, got %d", i, a, c)
+		}
+		done = fe.DoneFD(c, FDOptions{Borrow: true})
+		select {
+		case <-done:
+			t.Fatalf("iteration %d: new watch fired at once", i)
+		default:
+		}
+		unix.Close(d)
+		waitDone(t, done)
+		unix.Close(c)
+	}
+}
+
+// TestBorrowTwice checks that a descriptor borrowed by one watch is not shared with a second,
+// whose release would end the first.
+func TestBorrowTwice(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	if _, ok := be.(BorrowBackend); !ok {
+		t.Skip("backend cannot borrow descriptors")
+	}
+	fe := WithBackend(be)
+
+	a, b := socketpair(t)
+	ctx, cancel := fe.WithContextFD(context.Background(), a, FDOptions{Borrow: true})
+	defer cancel()
+	ctx2, cancel2 := fe.WithContextFD(context.Background(), a, FDOptions{Borrow: true})
+	waitDone(t, ctx2.Done())
+	if cause := context.Cause(ctx2); !errors.Is(cause, unix.EEXIST) {
+		t.Fatalf("expected EEXIST, got %v", cause)
+	}
+	cancel2()
+	assertNotDone(t, ctx.Done())
+
+	unix.Close(b)
+	waitDone(t, ctx.Done())
+}
@@ -0,0 +1,95 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDescriptorReuse opens and closes sockets rapidly, releasing half the watches early so that
+// their dup'd descriptor numbers are reused straight away, and checks that every notification
+// lands on the connection that was actually closed. Run it with -race.
+func TestDescriptorReuse(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe := WithBackend(be)
+	fe.SetLogger(log.New(io.Discard, "", 0))
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const (
+		rounds = 20
+		conns  = 16
+	)
+
+	for round := 0; round < rounds; round++ {
+		type watched struct {
+			client, server *net.TCPConn
+			ctx            context.Context
+			cancel         context.CancelFunc
+		}
+		ws := make([]watched, conns)
+		for i := range ws {
+			client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := l.AcceptTCP()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parent, cancel := context.WithCancel(context.Background())
+			ws[i] = watched{client, server, fe.WithContext(parent, server), cancel}
+
+			if i%2 == 1 {
+				cancel() // release early, freeing the dup'd descriptor for reuse
+			}
+		}
+
+		// Close the clients of every fourth connection; only those may report ErrConnClosed.
+		var wg sync.WaitGroup
+		for i := range ws {
+			if i%4 == 0 {
+				ws[i].client.Close()
+			}
+		}
+		for i := range ws {
+			w := ws[i]
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var cause error
+				select {
+				case <-w.ctx.Done():
+					cause = context.Cause(w.ctx)
+				case <-time.After(waitTime):
+				}
+				closed := errors.Is(cause, ErrConnClosed)
+				if i%4 == 0 && !closed {
+					t.Errorf("round %d conn %d: expected ErrConnClosed, got %v", round, i, cause)
+				} else if i%4 != 0 && closed {
+					t.Errorf("round %d conn %d: misattributed notification", round, i)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for _, w := range ws {
+			w.cancel()
+			w.client.Close()
+			w.server.Close()
+		}
+		if t.Failed() {
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (client, server *net.TCPConn) {
+	t.Helper()
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err = net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return client, server
+}
+
+func newShutdownBackend(t *testing.T) ShutdownBackend {
+	t.Helper()
+	sb, ok := NewDefaultBackend().(ShutdownBackend)
+	if !ok {
+		t.Skip("backend does not support Shutdown")
+	}
+	return sb
+}
+
+// TestShutdownDelivers checks that Shutdown waits for pending registrations to fire on their own.
+func TestShutdownDelivers(t *testing.T) {
+	be := newShutdownBackend(t)
+	fe := WithBackend(be)
+
+	client, server := tcpPair(t)
+	ctx := fe.WithContext(context.Background(), server)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- be.Shutdown(shutdownCtx)
+	}()
+
+	time.Sleep(waitTime)
+
+	// Registrations made while shutting down fire straight away.
+	_, other := tcpPair(t)
+	otherCtx := fe.WithContext(context.Background(), other)
+	select {
+	case <-otherCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for registration during Shutdown")
+	}
+	if cause := context.Cause(otherCtx); !errors.Is(cause, ErrBackendClosed) {
+		t.Fatalf("expected ErrBackendClosed, got %v", cause)
+	}
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned early: %v", err)
+	default:
+	}
+
+	client.Close()
+
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Shutdown")
+	}
+
+	<-ctx.Done()
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+}
+
+// TestShutdownDeadline checks that Shutdown gives up when its context expires, releasing pending
+// registrations with ErrBackendClosed.
+func TestShutdownDeadline(t *testing.T) {
+	be := newShutdownBackend(t)
+	fe := WithBackend(be)
+
+	_, server := tcpPair(t)
+	done := fe.Done(server)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), waitTime)
+	defer cancel()
+
+	start := time.Now()
+	if err := be.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if dur := time.Since(start); dur < waitTime {
+		t.Fatalf("expected to wait at least %v, but waited %v", waitTime, dur)
+	}
+
+	waitDone(t, done)
+
+	ctx := fe.WithContext(context.Background(), server)
+	<-ctx.Done()
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrBackendClosed) {
+		t.Fatalf("expected ErrBackendClosed, got %v", cause)
+	}
+}
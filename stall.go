@@ -0,0 +1,137 @@
+package blockuntilclosed
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrStalled is matched by the cause of a watch ended by slow-consumer detection; see
+// [WatchOptions].
+var ErrStalled = errors.New("send queue stalled")
+
+// StallError is the cause recorded when a socket's send queue stayed above
+// WatchOptions.StallBytes for WatchOptions.StallTimeout. It matches [ErrStalled], not
+// [ErrConnClosed]: the connection is still open, its peer has just stopped reading.
+type StallError struct {
+	Queued   int           // bytes in the send queue when the watch fired
+	Duration time.Duration // how long the queue had been over the threshold
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%v: %d bytes queued for %v", ErrStalled, e.Queued, e.Duration)
+}
+
+func (e *StallError) Unwrap() error {
+	return ErrStalled
+}
+
+// stallPoller polls the send queues of a frontend's watched sockets, from one goroutine that runs
+// only while there is something to poll.
+type stallPoller struct {
+	bytes   int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	watches map[*watch]*stallWatch
+	running bool
+}
+
+type stallWatch struct {
+	rc    syscall.RawConn // the caller's connection; the backend's dup is out of reach
+	since time.Time       // when the queue went over the threshold, or zero
+}
+
+func newStallPoller(opts WatchOptions) *stallPoller {
+	return &stallPoller{
+		bytes:   opts.StallBytes,
+		timeout: opts.StallTimeout,
+	}
+}
+
+func (sp *stallPoller) add(w *watch, rc syscall.RawConn) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.watches == nil {
+		sp.watches = make(map[*watch]*stallWatch)
+	}
+	sp.watches[w] = &stallWatch{rc: rc}
+	if !sp.running {
+		sp.running = true
+		go sp.run()
+	}
+}
+
+// interval polls a few times per timeout, within reason.
+func (sp *stallPoller) interval() time.Duration {
+	d := sp.timeout / 4
+	if d < 10*time.Millisecond {
+		d = 10 * time.Millisecond
+	} else if d > time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+func (sp *stallPoller) run() {
+	ticker := time.NewTicker(sp.interval())
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if !sp.poll(now) {
+			return
+		}
+	}
+}
+
+// poll checks every socket once, releasing those that have stalled. It reports false, and the
+// poller stops, once nothing is left to poll.
+func (sp *stallPoller) poll(now time.Time) bool {
+	type stalled struct {
+		w   *watch
+		err *StallError
+	}
+	var stalls []stalled
+
+	sp.mu.Lock()
+	for w, sw := range sp.watches {
+		if fired(w.reg) {
+			delete(sp.watches, w)
+			continue
+		}
+
+		var (
+			queued int
+			qerr   error
+		)
+		err := sw.rc.Control(func(fd uintptr) {
+			queued, qerr = sendQueue(int(fd))
+		})
+		if err == nil {
+			err = qerr
+		}
+		if err != nil { // closed, or not a socket
+			delete(sp.watches, w)
+			continue
+		}
+
+		switch {
+		case queued <= sp.bytes:
+			sw.since = time.Time{}
+		case sw.since.IsZero():
+			sw.since = now
+		case now.Sub(sw.since) >= sp.timeout:
+			delete(sp.watches, w)
+			stalls = append(stalls, stalled{w, &StallError{Queued: queued, Duration: now.Sub(sw.since)}})
+		}
+	}
+	more := len(sp.watches) > 0
+	sp.running = more
+	sp.mu.Unlock()
+
+	for _, s := range stalls {
+		s.w.reg.(Releaser).Release(s.err)
+	}
+	return more
+}
@@ -0,0 +1,84 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var stallOptions = WatchOptions{StallBytes: 64 << 10, StallTimeout: 200 * time.Millisecond}
+
+// flood writes to conn until it is closed or the write deadline passes.
+func flood(conn *net.TCPConn) {
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64<<10)
+	for {
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestStall(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe, err := WithBackendOptions(be, stallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, server := tcpPair(t) // the client never reads
+	ctx := fe.WithContext(context.Background(), server)
+	go flood(server)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the stalled socket to fire")
+	}
+	cause := context.Cause(ctx)
+	var stallErr *StallError
+	if !errors.As(cause, &stallErr) || !errors.Is(cause, ErrStalled) {
+		t.Fatalf("expected a *StallError, got %v", cause)
+	}
+	if errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("a stall is not a close: %v", cause)
+	}
+	if err := ClosedCause(ctx); err != nil {
+		t.Fatalf("expected no closed cause for a stall, got %v", err)
+	}
+	if stallErr.Queued <= stallOptions.StallBytes || stallErr.Duration < stallOptions.StallTimeout {
+		t.Fatalf("unexpected %+v", stallErr)
+	}
+	server.Close()
+}
+
+func TestStallReading(t *testing.T) {
+	be := NewDefaultBackend()
+	defer be.Close()
+	fe, err := WithBackendOptions(be, stallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := tcpPair(t)
+	go io.Copy(io.Discard, client)
+	ctx := fe.WithContext(context.Background(), server)
+
+	// Write steadily, well within what the reader keeps up with.
+	buf := make([]byte, 16<<10)
+	deadline := time.Now().Add(4 * stallOptions.StallTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := server.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("a reading peer is not stalled: %v", context.Cause(ctx))
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
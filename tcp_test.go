@@ -82,6 +82,24 @@ func TestTCP(t *testing.T) {
 	t.Log("waited", dur)
 }
 
+// TestDataIsNotClosed checks that data arriving on a connection, which a hijacking handler or a
+// TLS server may leave unread for a while, does not count as a close.
+func TestDataIsNotClosed(t *testing.T) {
+	client, server := tcpPair(t)
+
+	done := Done(server)
+	if done == nil {
+		t.Fatal("expected channel")
+	}
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	assertNotDone(t, done)
+
+	client.Close()
+	waitDone(t, done)
+}
+
 func BenchmarkTCP(b *testing.B) {
 	test := func(b *testing.B, doDone, waitDone bool) {
 		be := NewDefaultBackend()
@@ -168,3 +186,39 @@ func handleConnection(fe Frontend, conn *net.TCPConn, doDone, waitDone bool) {
 	conn.Read(buf)
 	conn.Write(buf)
 }
+
+// waitDone fails the test if done is not closed within a second.
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for done")
+	}
+}
+
+// assertNotDone fails the test if done is closed within waitTime.
+func assertNotDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("expected done to block")
+	case <-time.After(waitTime):
+	}
+}
+
+// waitWatches fails the test if be does not get down to want watches within a second.
+func waitWatches(t *testing.T, be LimitedBackend, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		n, _ := be.Watches()
+		if n == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d watches, got %d", want, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpInfo snapshots TCP_INFO for fd, or returns nil if fd is not a TCP socket.
+func tcpInfo(fd int) *TCPInfo {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil
+	}
+	return &TCPInfo{
+		State:         info.State,
+		RTT:           time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:        time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits:   info.Retransmits,
+		Probes:        info.Probes,
+		TotalRetrans:  info.Total_retrans,
+		Unacked:       info.Unacked,
+		Lost:          info.Lost,
+		BytesAcked:    info.Bytes_acked,
+		BytesReceived: info.Bytes_received,
+	}
+}
+
+// gaveUp reports whether a connection that errored was dropped while segments, or keepalive
+// probes, went unanswered: that is when the kernel may have given up on its peer, and SO_ERROR
+// is worth reading to find out. A peer that resets at once leaves its error to the caller.
+func gaveUp(info *TCPInfo) bool {
+	return info != nil && info.State == tcpClose && (info.Retransmits > 0 || info.Probes > 0)
+}
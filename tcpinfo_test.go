@@ -0,0 +1,92 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTCPInfoOnClose(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithContext(context.Background(), server)
+	client.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for context")
+	}
+
+	var closeErr *CloseError
+	if cause := context.Cause(ctx); !errors.As(cause, &closeErr) {
+		t.Fatalf("expected CloseError, got %v", cause)
+	}
+	info := closeErr.TCPInfo
+	if info == nil {
+		t.Fatal("expected TCPInfo")
+	}
+	t.Logf("%+v", info)
+	if info.State != tcpCloseWait {
+		t.Fatalf("expected CLOSE_WAIT, got %d", info.State)
+	}
+	if info.BytesReceived < 5 { // the FIN may be counted too
+		t.Fatalf("expected at least 5 bytes received, got %d", info.BytesReceived)
+	}
+}
+
+// TestResetLeftForCaller checks that the watch does not consume a reset's pending error: the
+// caller's next read must still fail with ECONNRESET rather than look like an orderly EOF.
+func TestResetLeftForCaller(t *testing.T) {
+	client, server := tcpPair(t)
+
+	ctx := WithContext(context.Background(), server)
+	if err := client.SetLinger(0); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for context")
+	}
+
+	var closeErr *CloseError
+	if cause := context.Cause(ctx); !errors.As(cause, &closeErr) || closeErr.Err != nil {
+		t.Fatalf("expected a CloseError without Err, got %v", cause)
+	}
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, unix.ECONNRESET) {
+		t.Fatalf("expected ECONNRESET from Read, got %v", err)
+	}
+}
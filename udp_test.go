@@ -0,0 +1,79 @@
+//go:build linux
+
+package blockuntilclosed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestUDPConnRefused sends to a closed localhost port and expects the ICMP port unreachable to
+// surface as an ECONNREFUSED close cause.
+func TestUDPConnRefused(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.LocalAddr().(*net.UDPAddr)
+	l.Close()
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := WithContext(context.Background(), conn)
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for ECONNREFUSED")
+	}
+
+	cause := context.Cause(ctx)
+	if !errors.Is(cause, syscall.ECONNREFUSED) {
+		t.Fatalf("expected ECONNREFUSED, got %v", cause)
+	}
+	if !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+	var closeErr *CloseError
+	if !errors.As(cause, &closeErr) {
+		t.Fatalf("expected CloseError, got %T", cause)
+	}
+	t.Log(cause)
+}
+
+// TestUDPDatagram checks that receiving datagrams does not fire.
+func TestUDPDatagram(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.DialUDP("udp", nil, l.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := Done(conn)
+	if done == nil {
+		t.Fatal("expected channel")
+	}
+
+	if _, err := l.WriteToUDP([]byte("hello"), conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	assertNotDone(t, done)
+}
@@ -0,0 +1,72 @@
+package blockuntilclosed
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// maxUnwrapDepth bounds Unwrap in case a wrapper unwraps to itself.
+const maxUnwrapDepth = 16
+
+// UnwrapFunc returns the connection wrapped by conn, or false if it does not recognize conn.
+type UnwrapFunc func(conn net.Conn) (net.Conn, bool)
+
+var unwrappers struct {
+	sync.RWMutex
+	funcs []UnwrapFunc
+}
+
+// RegisterUnwrapper adds a hook used by Unwrap for wrappers that have neither a NetConn nor an
+// Unwrap method, such as the connections returned by netutil.LimitListener.
+func RegisterUnwrapper(f UnwrapFunc) {
+	unwrappers.Lock()
+	defer unwrappers.Unlock()
+	unwrappers.funcs = append(unwrappers.funcs, f)
+}
+
+// Unwrap peels wrapper connections until it finds one that is a [Conn], for wrappers that cannot be
+// watched themselves. It understands NetConn() net.Conn (as on [*crypto/tls.Conn]), Unwrap()
+// net.Conn, and hooks added with RegisterUnwrapper. An HTTPS server's ConnContext, say, can pass
+// what it returns to [WithContext].
+func Unwrap(conn net.Conn) (Conn, bool) {
+	for i := 0; i < maxUnwrapDepth && conn != nil; i++ {
+		if sconn, ok := conn.(Conn); ok {
+			return sconn, true
+		}
+
+		next, ok := unwrapOnce(conn)
+		if !ok {
+			return nil, false
+		}
+		conn = next
+	}
+	return nil, false
+}
+
+// mustUnwrap is Unwrap for helpers that are handed a net.Conn: it reports what it failed to unwrap.
+func mustUnwrap(conn net.Conn) (Conn, error) {
+	sconn, ok := Unwrap(conn)
+	if !ok {
+		return nil, fmt.Errorf("no syscall.Conn found in %T", conn)
+	}
+	return sconn, nil
+}
+
+func unwrapOnce(conn net.Conn) (net.Conn, bool) {
+	switch c := conn.(type) {
+	case interface{ NetConn() net.Conn }:
+		return c.NetConn(), true
+	case interface{ Unwrap() net.Conn }:
+		return c.Unwrap(), true
+	}
+
+	unwrappers.RLock()
+	defer unwrappers.RUnlock()
+	for _, f := range unwrappers.funcs {
+		if next, ok := f(conn); ok {
+			return next, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package blockuntilclosed
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type unwrapConn struct{ net.Conn }
+
+func (c unwrapConn) Unwrap() net.Conn { return c.Conn }
+
+type hookedConn struct{ net.Conn }
+
+type opaqueConn struct{ net.Conn }
+
+func TestUnwrap(t *testing.T) {
+	RegisterUnwrapper(func(conn net.Conn) (net.Conn, bool) {
+		if c, ok := conn.(hookedConn); ok {
+			return c.Conn, true
+		}
+		return nil, false
+	})
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tcpConn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	for _, tc := range []struct {
+		name string
+		conn net.Conn
+		ok   bool
+	}{
+		{"syscall.Conn", tcpConn, true},
+		{"tls.Conn", tls.Client(tcpConn, &tls.Config{}), true},
+		{"Unwrap", unwrapConn{tcpConn}, true},
+		{"hook", hookedConn{unwrapConn{tcpConn}}, true},
+		{"opaque", opaqueConn{tcpConn}, false},
+		{"nil", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sconn, ok := Unwrap(tc.conn)
+			if ok != tc.ok {
+				t.Fatalf("expected ok=%v, got %v", tc.ok, ok)
+			}
+			if ok && sconn != tcpConn {
+				t.Fatalf("expected %p, got %v", tcpConn, sconn)
+			}
+		})
+	}
+}
+
+// TestTLSWithContext checks that an HTTPS server can use WithContext on the *tls.Conn it is given,
+// once unwrapped.
+func TestTLSWithContext(t *testing.T) {
+	ctxs := make(chan context.Context, 1)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if _, ok := c.(*tls.Conn); !ok {
+			t.Errorf("expected *tls.Conn, got %T", c)
+		}
+		sc, ok := Unwrap(c)
+		if !ok {
+			t.Errorf("no Conn found in %T", c)
+		}
+		ctx = WithContext(ctx, sc)
+		ctxs <- ctx
+		return ctx
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), srv.Client().Transport.(*http.Transport).TLSClientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := <-ctxs
+	assertNotDone(t, ctx.Done())
+
+	conn.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for context")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", cause)
+	}
+}